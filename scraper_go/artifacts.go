@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+// artifactNamePattern restricts artifact names to safe S3 key segments
+var artifactNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// capturedArtifact is a screenshot or PDF captured in the browser, waiting
+// to be uploaded with the result
+type capturedArtifact struct {
+	Name        string
+	Type        string
+	ContentType string
+	Data        []byte
+}
+
+// validateArtifacts checks the task's artifact requests before anything is
+// fetched
+func validateArtifacts(task *models.TaskMessage) error {
+	if len(task.Options.Artifacts) > 0 && task.Options.Engine == engineColly {
+		return fmt.Errorf("%w: artifacts need the chrome engine", errInvalidTask)
+	}
+
+	for i, artifact := range task.Options.Artifacts {
+		if artifact.Name != "" && !artifactNamePattern.MatchString(artifact.Name) {
+			return fmt.Errorf("%w: artifact %d: name may only contain letters, digits, '-' and '_'", errInvalidTask, i)
+		}
+		switch artifact.Type {
+		case "full_page_screenshot", "viewport_screenshot", "pdf":
+		case "element_screenshot":
+			if artifact.Selector == "" {
+				return fmt.Errorf("%w: artifact %d (%s): selector is required", errInvalidTask, i, artifact.Type)
+			}
+		default:
+			return fmt.Errorf("%w: artifact %d: unknown type %q", errInvalidTask, i, artifact.Type)
+		}
+	}
+	return nil
+}
+
+// captureArtifacts captures the task's screenshots and PDFs of the page as it
+// is now. Each capture is bounded by the browser action timeout.
+func (se *ScraperEngine) captureArtifacts(ctx context.Context, task *models.TaskMessage) ([]capturedArtifact, error) {
+	var artifacts []capturedArtifact
+	names := artifactNames(task.Options.Artifacts)
+
+	for i, request := range task.Options.Artifacts {
+		artifact := capturedArtifact{
+			Name:        names[i],
+			Type:        request.Type,
+			ContentType: "image/png",
+		}
+
+		var capture chromedp.Action
+		switch request.Type {
+		case "full_page_screenshot":
+			capture = chromedp.FullScreenshot(&artifact.Data, 100)
+		case "viewport_screenshot":
+			capture = chromedp.CaptureScreenshot(&artifact.Data)
+		case "element_screenshot":
+			capture = chromedp.Screenshot(request.Selector, &artifact.Data, chromedp.NodeVisible)
+		case "pdf":
+			artifact.ContentType = "application/pdf"
+			capture = chromedp.ActionFunc(func(ctx context.Context) error {
+				var err error
+				artifact.Data, _, err = page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+				return err
+			})
+		default:
+			return nil, fmt.Errorf("%w: artifact %d: unknown type %q", errInvalidTask, i, request.Type)
+		}
+
+		captureCtx, cancel := context.WithTimeout(ctx, defaultActionTimeout)
+		err := chromedp.Run(captureCtx, capture)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture artifact %d (%s): %w", i, request.Type, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id":   task.TaskID,
+		"artifacts": len(artifacts),
+	}).Debug("Artifacts captured")
+
+	return artifacts, nil
+}
+
+// artifactNames returns the file names of a task's artifacts: the requested
+// name or the type, numbered from the second artifact with the same name.
+// Requested names are kept, numbered names skip them.
+func artifactNames(requests []models.ArtifactRequest) []string {
+	names := make([]string, len(requests))
+	used := make(map[string]bool)
+	for i, request := range requests {
+		if request.Name != "" && !used[request.Name] {
+			names[i] = request.Name
+			used[request.Name] = true
+		}
+	}
+
+	for i, request := range requests {
+		if names[i] != "" {
+			continue
+		}
+		base := request.Name
+		if base == "" {
+			base = request.Type
+		}
+		name := base
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// artifactExtension returns the file extension of an artifact content type
+func artifactExtension(contentType string) string {
+	if contentType == "application/pdf" {
+		return "pdf"
+	}
+	return "png"
+}
+
+// uploadArtifacts uploads the artifacts of a task next to its result and
+// returns the uploaded ones with their S3 locations. A failed upload does
+// not stop the others; the errors are returned joined.
+func (jp *JobProcessor) uploadArtifacts(taskID string, captured []capturedArtifact) ([]models.Artifact, error) {
+	var artifacts []models.Artifact
+	var errs []error
+	for _, artifact := range captured {
+		fileName := artifact.Name + "." + artifactExtension(artifact.ContentType)
+		location, err := jp.s3Uploader.UploadArtifact(taskID, fileName, artifact.ContentType, artifact.Data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		artifacts = append(artifacts, models.Artifact{
+			Name:        artifact.Name,
+			Type:        artifact.Type,
+			ContentType: artifact.ContentType,
+			Size:        len(artifact.Data),
+			S3Location:  location,
+		})
+	}
+	return artifacts, errors.Join(errs...)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+// Engine names used by the engine option and in result metadata
+const (
+	engineAuto   = "auto"
+	engineColly  = "colly"
+	engineChrome = "chrome"
+)
+
+// spaMaxVisibleText is the visible text length below which a page with an
+// app mount point or a noscript warning is treated as a client-rendered shell
+const spaMaxVisibleText = 200
+
+// spaRootPattern matches the empty mount point of a client-rendered app
+var spaRootPattern = regexp.MustCompile(`(?i)<div[^>]+id=["'](root|app|__next|__nuxt|svelte)["'][^>]*>\s*</div>`)
+
+// noscriptWarnings are phrases of <noscript> messages asking for JavaScript
+var noscriptWarnings = []string{
+	"enable javascript",
+	"javascript is required",
+	"javascript is disabled",
+	"requires javascript",
+	"turn on javascript",
+}
+
+// engineDecision is the remembered engine of a domain
+type engineDecision struct {
+	js        bool
+	reason    string
+	decidedAt time.Time
+}
+
+// EngineDecisionCache remembers per host whether auto engine tasks need
+// Chrome, so only the first task for a host pays for the probe
+type EngineDecisionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]engineDecision
+}
+
+// NewEngineDecisionCache creates a decision cache whose entries expire after ttl
+func NewEngineDecisionCache(ttl time.Duration) *EngineDecisionCache {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &EngineDecisionCache{
+		ttl:     ttl,
+		entries: make(map[string]engineDecision),
+	}
+}
+
+// Lookup returns the remembered decision for a host
+func (c *EngineDecisionCache) Lookup(host string) (engineDecision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	decision, ok := c.entries[strings.ToLower(host)]
+	if !ok || time.Since(decision.decidedAt) >= c.ttl {
+		return engineDecision{}, false
+	}
+	return decision, true
+}
+
+// Remember stores the decision for a host
+func (c *EngineDecisionCache) Remember(host string, js bool, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[strings.ToLower(host)] = engineDecision{js: js, reason: reason, decidedAt: time.Now()}
+}
+
+// scrapeAuto scrapes a task with engine "auto": the page is probed with
+// Colly and fetched again with Chrome when the probe fails with a transient
+// error or its result suggests the page is rendered client-side. A working
+// probe is remembered for the host as Colly, Chrome only once it succeeded.
+func (se *ScraperEngine) scrapeAuto(task *models.TaskMessage) (*ScrapeOutput, error) {
+	target, err := url.Parse(task.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := target.Hostname()
+
+	// Browser actions, infinite scroll and artifacts need Chrome
+	if browserOnly(task) {
+		output, err := se.scrapeOnce(withEngine(task, true))
+		return withEngineMetadata(output, true, "required", ""), err
+	}
+
+	if decision, ok := se.engines.Lookup(host); ok {
+		output, err := se.scrapeOnce(withEngine(task, decision.js))
+		return withEngineMetadata(output, decision.js, "cached", decision.reason), err
+	}
+
+	collyTask := withEngine(task, false)
+	page, probeErr := se.fetchPage(collyTask, task.URL)
+	var reason string
+	switch {
+	case probeErr != nil && !retryableError(probeErr):
+		return nil, probeErr
+	case probeErr != nil:
+		reason = "probe_failed"
+	default:
+		output, extractErr := se.extractFromPage(collyTask, page)
+		if reason = needsJS(task, page, extractErr); reason == "" {
+			se.engines.Remember(host, false, "")
+			if task.Options.Pagination != nil {
+				output, extractErr = se.scrapePaginated(collyTask, page)
+			}
+			return withEngineMetadata(output, false, "probe", ""), extractErr
+		}
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"host":    host,
+		"reason":  reason,
+	}).Info("Page needs JavaScript, switching to Chrome")
+
+	output, err := se.scrapeOnce(withEngine(task, true))
+	if err != nil && probeErr != nil {
+		// Chrome did not help either, so report the original failure
+		se.logger.WithError(err).WithField("task_id", task.TaskID).Warn("Chrome fallback failed")
+		return withEngineMetadata(output, false, "probe", reason), probeErr
+	}
+	if err == nil {
+		se.engines.Remember(host, true, reason)
+	}
+	return withEngineMetadata(output, true, "probe", reason), err
+}
+
+// needsJS returns why a page fetched without JavaScript needs Chrome, or ""
+// when the Colly result can be used: required fields are missing, the wait
+// selector is absent, or the page is an empty single page app shell
+func needsJS(task *models.TaskMessage, page *fetchedPage, extractErr error) string {
+	if detectResponseFormat(task.Options.ResponseFormat, page) != "html" {
+		return ""
+	}
+	if errors.Is(extractErr, errSelectorMiss) {
+		return "required_fields_missing"
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return ""
+	}
+	if task.Options.WaitForElement != "" && doc.Find(task.Options.WaitForElement).Length() == 0 {
+		return "wait_selector_missing"
+	}
+
+	// Pages with real content are server-rendered whatever their noscript
+	// messages say
+	body := doc.Find("body").Clone()
+	body.Find("script, style, noscript, template").Remove()
+	if len(strings.TrimSpace(body.Text())) >= spaMaxVisibleText {
+		return ""
+	}
+
+	if spaRootPattern.Match(page.Body) {
+		return "spa_shell"
+	}
+	noscript := strings.ToLower(doc.Find("noscript").Text())
+	for _, warning := range noscriptWarnings {
+		if strings.Contains(noscript, warning) {
+			return "noscript_warning"
+		}
+	}
+	return ""
+}
+
+// withEngine returns a copy of the task fetched with Chrome or Colly
+func withEngine(task *models.TaskMessage, js bool) *models.TaskMessage {
+	resolved := *task
+	resolved.Options.Engine = ""
+	resolved.Options.EnableJS = js
+	return &resolved
+}
+
+// withEngineMetadata records the engine chosen for an auto engine task
+func withEngineMetadata(output *ScrapeOutput, js bool, decision, reason string) *ScrapeOutput {
+	if output == nil {
+		output = &ScrapeOutput{}
+	}
+	if output.Metadata == nil {
+		output.Metadata = make(map[string]interface{})
+	}
+	output.Metadata["engine"] = engineColly
+	if js {
+		output.Metadata["engine"] = engineChrome
+	}
+	output.Metadata["engine_decision"] = decision
+	if reason != "" {
+		output.Metadata["engine_reason"] = reason
+	}
+	return output
+}
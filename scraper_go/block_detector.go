@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+const (
+	// blockScanSize is how much of a body is searched for block markers
+	blockScanSize = 64 * 1024
+	// blockMinBodySize is the size below which a script-only page is treated
+	// as a challenge stub
+	blockMinBodySize = 512
+)
+
+// blockMarkers are fragments of the challenge and block pages served by
+// common bot protection vendors, matched against the lowercased body.
+// Interstitial markers only appear on challenge pages; the others are also
+// found on healthy pages and only count on a 403, 429 or 503 response.
+var blockMarkers = []struct {
+	vendor       string
+	marker       string
+	interstitial bool
+}{
+	{"cloudflare", "cf-browser-verification", true},
+	{"cloudflare", "cf-chl-", true},
+	{"cloudflare", "<title>just a moment...</title>", true},
+	{"cloudflare", "attention required! | cloudflare", true},
+	{"cloudflare", "/cdn-cgi/challenge-platform/", false},
+	{"akamai", "errors.edgesuite.net", false},
+	{"akamai", "<title>access denied</title>", false},
+	{"datadome", "captcha-delivery.com", false},
+	{"perimeterx", "px-captcha", false},
+	{"imperva", "_incapsula_resource", false},
+	{"generic", "pardon our interruption", true},
+}
+
+// blockedError is returned when a page stays blocked after every step of the
+// task's block escalation ladder
+type blockedError struct {
+	URL    string
+	Reason string
+	Steps  []map[string]interface{}
+	Err    error // the last fetch error, if any
+}
+
+// Error implements the error interface
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("blocked: %s (%s)", e.URL, e.Reason)
+}
+
+// Unwrap returns the last fetch error
+func (e *blockedError) Unwrap() error {
+	return e.Err
+}
+
+// detectBlock returns why a fetch looks blocked, or "" when it does not:
+// 403 and 429 responses, pages carrying a known challenge marker and tiny
+// script-only error pages
+func detectBlock(page *fetchedPage, err error) string {
+	var body []byte
+	status := 0
+
+	var statusErr *httpStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		body, status = statusErr.body, statusErr.StatusCode
+	case err != nil || page == nil:
+		return ""
+	default:
+		body, status = page.Body, page.StatusCode
+	}
+
+	scan := body
+	if len(scan) > blockScanSize {
+		scan = scan[:blockScanSize]
+	}
+	lower := bytes.ToLower(scan)
+	blockStatus := status == http.StatusForbidden || status == http.StatusTooManyRequests
+	for _, m := range blockMarkers {
+		if (m.interstitial || blockStatus || status == http.StatusServiceUnavailable) && bytes.Contains(lower, []byte(m.marker)) {
+			return m.vendor + "_challenge"
+		}
+	}
+
+	if blockStatus {
+		return fmt.Sprintf("http_%d", status)
+	}
+
+	if status != 0 && (status < 200 || status >= 300) && len(bytes.TrimSpace(body)) < blockMinBodySize && bytes.Contains(lower, []byte("<script")) {
+		return "small_body"
+	}
+	return ""
+}
+
+// blockEscalationSteps are the steps a block escalation ladder may use
+var blockEscalationSteps = toSet([]string{"rotate_proxy", "stealth_js"})
+
+// validateBlockEscalation checks the task's block escalation ladder before
+// anything is fetched, so an unknown step fails even when nothing is blocked
+func validateBlockEscalation(task *models.TaskMessage) error {
+	for _, name := range task.Options.BlockEscalation {
+		if !blockEscalationSteps[name] {
+			return fmt.Errorf("%w: unknown block escalation step: %s", errInvalidTask, name)
+		}
+	}
+	return nil
+}
+
+// escalateBlock walks the task's block escalation ladder after a blocked
+// fetch: "rotate_proxy" refetches through the next PROXY_LIST proxy and
+// "stealth_js" refetches with Chrome in stealth mode. The steps taken are
+// attached to the returned page, or to the blockedError when every step is
+// blocked too.
+func (se *ScraperEngine) escalateBlock(task *models.TaskMessage, targetURL, reason string, fetchErr error) (*fetchedPage, error) {
+	steps := []map[string]interface{}{{
+		"step":    "initial",
+		"engine":  fetchEngine(task),
+		"outcome": "blocked",
+		"reason":  reason,
+	}}
+
+	for _, name := range task.Options.BlockEscalation {
+		stepTask := *task
+		step := map[string]interface{}{"step": name}
+
+		switch name {
+		case "rotate_proxy":
+			proxyURL := se.config.NextPoolProxy()
+			if proxyURL == "" {
+				step["outcome"] = "skipped"
+				step["reason"] = "no proxies configured"
+				steps = append(steps, step)
+				continue
+			}
+			stepTask.Options.ProxyURL = proxyURL
+		case "stealth_js":
+			stepTask.Options.EnableJS = true
+			stepTask.Options.StealthMode = true
+		default:
+			return nil, fmt.Errorf("%w: unknown block escalation step: %s", errInvalidTask, name)
+		}
+		step["engine"] = fetchEngine(&stepTask)
+
+		se.logger.WithFields(logrus.Fields{
+			"task_id": task.TaskID,
+			"url":     targetURL,
+			"reason":  reason,
+			"step":    name,
+		}).Info("Page blocked, escalating")
+
+		page, err := se.fetchWithEngine(&stepTask, targetURL)
+		if stepReason := detectBlock(page, err); stepReason != "" {
+			reason, fetchErr = stepReason, err
+			step["outcome"] = "blocked"
+			step["reason"] = stepReason
+		} else if err != nil {
+			fetchErr = err
+			step["outcome"] = "error"
+			step["reason"] = err.Error()
+		} else {
+			step["outcome"] = "ok"
+			page.Escalation = append(steps, step)
+			return page, nil
+		}
+		steps = append(steps, step)
+	}
+
+	return nil, &blockedError{URL: targetURL, Reason: reason, Steps: steps, Err: fetchErr}
+}
+
+// fetchEngine names the engine a task is fetched with
+func fetchEngine(task *models.TaskMessage) string {
+	if usesChrome(task) {
+		if task.Options.StealthMode {
+			return engineChrome + "_stealth"
+		}
+		return engineChrome
+	}
+	return engineColly
+}
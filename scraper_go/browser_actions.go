@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+const (
+	// defaultActionTimeout bounds a browser action without its own timeout
+	defaultActionTimeout = 10 * time.Second
+	// networkIdleTime is how long no request may be in flight for the
+	// network to count as idle
+	networkIdleTime = 500 * time.Millisecond
+)
+
+// actionError is returned when a browser action fails, carrying the index of
+// the failing step in the task's actions
+type actionError struct {
+	Index int
+	Type  string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *actionError) Error() string {
+	return fmt.Sprintf("action %d (%s) failed: %v", e.Index, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying browser error
+func (e *actionError) Unwrap() error {
+	return e.Err
+}
+
+// pageSnapshot is the page as captured by a snapshot action
+type pageSnapshot struct {
+	Name string
+	URL  string
+	HTML string
+}
+
+// validateActions checks the task's browser actions before anything is
+// fetched, so a malformed script fails as an invalid task
+func validateActions(task *models.TaskMessage) error {
+	if len(task.Options.Actions) > 0 && task.Options.Engine == engineColly {
+		return fmt.Errorf("%w: browser actions need the chrome engine", errInvalidTask)
+	}
+
+	for i, action := range task.Options.Actions {
+		var missing string
+		switch action.Type {
+		case "navigate":
+			if action.URL == "" {
+				missing = "url"
+			}
+		case "click", "type", "select", "hover", "wait_for_selector":
+			if action.Selector == "" {
+				missing = "selector"
+			}
+		case "press":
+			if action.Value == "" {
+				missing = "value"
+			}
+		case "evaluate":
+			if action.Script == "" {
+				missing = "script"
+			}
+		case "wait_timeout":
+			if action.Duration <= 0 {
+				missing = "duration"
+			}
+		case "scroll", "wait_network_idle", "snapshot":
+		default:
+			return fmt.Errorf("%w: action %d: unknown type %q", errInvalidTask, i, action.Type)
+		}
+		if missing != "" {
+			return fmt.Errorf("%w: action %d (%s): %s is required", errInvalidTask, i, action.Type, missing)
+		}
+	}
+	return nil
+}
+
+// runBrowserActions runs the task's actions in order on the rendered page.
+// Each step is bounded by its own timeout; the first failing step stops the
+// script with an actionError. Navigate steps are checked against robots.txt
+// and hold a host slot like page loads. Snapshot actions capture the page as
+// it is at that step.
+func (se *ScraperEngine) runBrowserActions(ctx context.Context, task *models.TaskMessage) ([]map[string]interface{}, []pageSnapshot, error) {
+	var steps []map[string]interface{}
+	var snapshots []pageSnapshot
+
+	for i, action := range task.Options.Actions {
+		timeout := defaultActionTimeout
+		if action.Timeout > 0 {
+			timeout = time.Duration(action.Timeout) * time.Second
+		}
+		if action.Type == "wait_timeout" {
+			timeout += time.Duration(action.Duration) * time.Millisecond
+		}
+
+		step := map[string]interface{}{"index": i, "type": action.Type}
+		start := time.Now()
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+
+		var result interface{}
+		var snapshot pageSnapshot
+		var err error
+		if action.Type == "navigate" {
+			err = se.checkRobots(task, action.URL)
+		}
+		if err == nil {
+			err = chromedp.Run(stepCtx, se.browserAction(action, &result, &snapshot))
+		}
+		cancel()
+
+		step["duration_ms"] = time.Since(start).Milliseconds()
+		if err != nil {
+			se.logger.WithError(err).WithFields(logrus.Fields{
+				"task_id": task.TaskID,
+				"action":  i,
+				"type":    action.Type,
+			}).Warn("Browser action failed")
+			return steps, snapshots, &actionError{Index: i, Type: action.Type, Err: err}
+		}
+
+		switch action.Type {
+		case "evaluate":
+			step["result"] = result
+		case "snapshot":
+			snapshot.Name = action.Name
+			if snapshot.Name == "" {
+				snapshot.Name = fmt.Sprintf("snapshot_%d", len(snapshots)+1)
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		steps = append(steps, step)
+	}
+	return steps, snapshots, nil
+}
+
+// browserAction translates a task action into chromedp actions
+func (se *ScraperEngine) browserAction(action models.BrowserAction, result *interface{}, snapshot *pageSnapshot) chromedp.Action {
+	switch action.Type {
+	case "navigate":
+		return se.navigate(action.URL)
+	case "click":
+		return chromedp.Click(action.Selector, chromedp.NodeVisible)
+	case "type":
+		return chromedp.SendKeys(action.Selector, action.Value, chromedp.NodeVisible)
+	case "select":
+		return chromedp.Tasks{
+			chromedp.WaitReady(action.Selector),
+			chromedp.Evaluate(elementScript(action.Selector,
+				fmt.Sprintf(`el.value = %s; el.dispatchEvent(new Event("input", {bubbles: true})); el.dispatchEvent(new Event("change", {bubbles: true}));`, jsString(action.Value)),
+			), nil),
+		}
+	case "hover":
+		return hoverAction(action.Selector)
+	case "press":
+		key := keyByName(action.Value)
+		if action.Selector != "" {
+			return chromedp.SendKeys(action.Selector, key, chromedp.NodeVisible)
+		}
+		return chromedp.KeyEvent(key)
+	case "scroll":
+		if action.Selector != "" {
+			return chromedp.ScrollIntoView(action.Selector)
+		}
+		if action.X == 0 && action.Y == 0 {
+			return chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil)
+		}
+		return chromedp.Evaluate(fmt.Sprintf(`window.scrollBy(%d, %d)`, action.X, action.Y), nil)
+	case "wait_for_selector":
+		return chromedp.WaitVisible(action.Selector)
+	case "wait_timeout":
+		return chromedp.Sleep(time.Duration(action.Duration) * time.Millisecond)
+	case "wait_network_idle":
+		return waitNetworkIdle()
+	case "evaluate":
+		return chromedp.Evaluate(action.Script, result, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		})
+	case "snapshot":
+		return chromedp.Tasks{
+			chromedp.OuterHTML("html", &snapshot.HTML),
+			chromedp.Location(&snapshot.URL),
+		}
+	}
+	return chromedp.ActionFunc(func(context.Context) error {
+		return fmt.Errorf("%w: unknown action type %q", errInvalidTask, action.Type)
+	})
+}
+
+// hoverAction moves the mouse over the centre of the first element matching
+// selector
+func hoverAction(selector string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := chromedp.ScrollIntoView(selector).Do(ctx); err != nil {
+			return err
+		}
+		var point struct{ X, Y float64 }
+		script := elementScript(selector, `const r = el.getBoundingClientRect(); return {X: r.left + r.width / 2, Y: r.top + r.height / 2};`)
+		if err := chromedp.Evaluate(script, &point).Do(ctx); err != nil {
+			return err
+		}
+		return chromedp.MouseEvent(input.MouseMoved, point.X, point.Y).Do(ctx)
+	})
+}
+
+// waitNetworkIdle waits until no request has been in flight for
+// networkIdleTime
+func waitNetworkIdle() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		listenCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var mu sync.Mutex
+		inFlight := make(map[network.RequestID]bool)
+		lastActivity := time.Now()
+		chromedp.ListenTarget(listenCtx, func(ev interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch ev := ev.(type) {
+			case *network.EventRequestWillBeSent:
+				inFlight[ev.RequestID] = true
+			case *network.EventLoadingFinished:
+				delete(inFlight, ev.RequestID)
+			case *network.EventLoadingFailed:
+				delete(inFlight, ev.RequestID)
+			default:
+				return
+			}
+			lastActivity = time.Now()
+		})
+
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				mu.Lock()
+				idle := len(inFlight) == 0 && time.Since(lastActivity) >= networkIdleTime
+				mu.Unlock()
+				if idle {
+					return nil
+				}
+			}
+		}
+	})
+}
+
+// elementScript wraps body in a function run on the first element matching
+// selector, failing when there is none
+func elementScript(selector, body string) string {
+	return fmt.Sprintf(`(() => {
+	const el = document.querySelector(%s);
+	if (!el) { throw new Error("no element matches " + %s); }
+	%s
+})()`, jsString(selector), jsString(selector), body)
+}
+
+// jsString quotes a string as a JavaScript string literal
+func jsString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
+// keyByName returns the key sequence of a key name such as "Enter" or
+// "ArrowDown"; other values are typed as they are
+func keyByName(name string) string {
+	for r, key := range kb.Keys {
+		if key.Key == name && len([]rune(name)) > 1 {
+			return string(r)
+		}
+	}
+	return name
+}
+
+// extractSnapshots extracts the task schema from every snapshot, returning
+// them in order with their name and URL
+func (se *ScraperEngine) extractSnapshots(task *models.TaskMessage, snapshots []pageSnapshot) ([]map[string]interface{}, error) {
+	var extracted []map[string]interface{}
+	var firstErr error
+	for _, snapshot := range snapshots {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(snapshot.HTML))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %w", snapshot.Name, err)
+		}
+		data, _, err := se.extractDataFromHTML(doc.Selection, task.Schema)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("snapshot %s: %w", snapshot.Name, err)
+		}
+		extracted = append(extracted, map[string]interface{}{
+			"name": snapshot.Name,
+			"url":  snapshot.URL,
+			"data": data,
+		})
+	}
+	return extracted, firstErr
+}
+
+// usesChrome reports whether a task is fetched with Chrome: JavaScript
+// rendering is enabled or the task needs the browser
+func usesChrome(task *models.TaskMessage) bool {
+	return task.Options.EnableJS || browserOnly(task)
+}
+
+// browserOnly reports whether a task uses options only Chrome supports:
+// browser actions, infinite scroll or artifacts
+func browserOnly(task *models.TaskMessage) bool {
+	return len(task.Options.Actions) > 0 || task.Options.InfiniteScroll != nil || len(task.Options.Artifacts) > 0
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+	"github.com/sirupsen/logrus"
+	"scraper-go/config"
+)
+
+// errBrowserPoolClosed is returned by Acquire once the pool is closed
+var errBrowserPoolClosed = errors.New("browser pool closed")
+
+// memorySampleInterval is how often the memory of pooled browsers is measured
+const memorySampleInterval = 10 * time.Second
+
+// pooledBrowser is a long-lived Chrome process shared by several tasks
+type pooledBrowser struct {
+	id          int
+	ctx         context.Context // browser context, parent of every tab
+	cancel      context.CancelFunc
+	allocCancel context.CancelFunc
+	pid         int
+	rss         int64 // last memory sample of the process tree
+	startedAt   time.Time
+	stealth     bool // launched with the stealth flags
+	tabs        int  // tabs in use
+	tasks       int  // tasks served
+	retiring    bool // closed once its last tab is released
+	closed      bool
+}
+
+// BrowserLease is a tab in its own incognito browser context, on a pooled
+// browser. Release must be called once the task is done with it.
+type BrowserLease struct {
+	Ctx     context.Context
+	pool    *BrowserPool
+	browser *pooledBrowser
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// BrowserPool keeps long-lived Chrome processes and hands out isolated tabs
+// to tasks. Browsers are launched on demand up to BROWSER_POOL_SIZE, serve at
+// most BROWSER_MAX_TABS tasks at a time, and are recycled after
+// BROWSER_RECYCLE_AFTER tasks or when their memory use exceeds
+// BROWSER_MAX_MEMORY_MB. Crashed browsers are replaced. Retiring browsers
+// finish their tabs outside of the pool size, and stealth tasks get browsers
+// launched with the stealth flags.
+type BrowserPool struct {
+	config    *config.Config
+	logger    *logrus.Logger
+	mu        sync.Mutex
+	available *sync.Cond
+	browsers  []*pooledBrowser
+	launching int
+	nextID    int
+	closed    bool
+
+	// stats
+	launches  int64
+	recycles  int64
+	crashes   int64
+	tasks     int64
+	waits     int64
+	waitTotal time.Duration
+}
+
+// NewBrowserPool creates an empty browser pool
+func NewBrowserPool(cfg *config.Config, logger *logrus.Logger) *BrowserPool {
+	pool := &BrowserPool{
+		config: cfg,
+		logger: logger,
+	}
+	pool.available = sync.NewCond(&pool.mu)
+	return pool
+}
+
+// Acquire returns a tab on the least busy browser launched with or without
+// the stealth flags, launching a browser when all are busy and the pool is
+// not full, or waiting for a free tab otherwise. The tab runs in a new
+// incognito browser context using proxyURL, if set.
+func (p *BrowserPool) Acquire(proxyURL string, stealth bool) (*BrowserLease, error) {
+	start := time.Now()
+	browser, err := p.reserveTab(stealth || p.config.DefaultStealthMode)
+	if err != nil {
+		return nil, err
+	}
+	if waited := time.Since(start); waited >= 10*time.Millisecond {
+		p.mu.Lock()
+		p.waits++
+		p.waitTotal += waited
+		p.mu.Unlock()
+	}
+
+	ctx, cancel := chromedp.NewContext(browser.ctx, chromedp.WithNewBrowserContext(
+		func(params *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+			if proxyURL != "" {
+				return params.WithProxyServer(proxyURL)
+			}
+			return params
+		},
+	))
+	lease := &BrowserLease{Ctx: ctx, pool: p, browser: browser, cancel: cancel}
+
+	// Create the tab now, so that task timeouts do not end its event loop
+	if err := chromedp.Run(ctx); err != nil {
+		lease.Release()
+		return nil, fmt.Errorf("failed to open tab: %w", err)
+	}
+	return lease, nil
+}
+
+// Release closes the tab and its browser context and returns the slot to
+// the pool, recycling the browser when it has served enough tasks or uses
+// too much memory
+func (l *BrowserLease) Release() {
+	l.once.Do(func() {
+		l.cancel()
+		l.pool.release(l.browser)
+	})
+}
+
+// Close shuts down every browser of the pool
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	browsers := p.browsers
+	p.browsers = nil
+	for _, browser := range browsers {
+		browser.closed = true
+	}
+	p.available.Broadcast()
+	p.mu.Unlock()
+
+	for _, browser := range browsers {
+		browser.cancel()
+		browser.allocCancel()
+	}
+}
+
+// WriteMetrics writes browser pool metrics
+func (p *BrowserPool) WriteMetrics(w io.Writer) {
+	p.mu.Lock()
+	tabs := 0
+	ids := make([]int, 0, len(p.browsers))
+	memory := make([]int64, 0, len(p.browsers))
+	for _, browser := range p.browsers {
+		tabs += browser.tabs
+		ids = append(ids, browser.id)
+		memory = append(memory, browser.rss)
+	}
+
+	fmt.Fprintf(w, `# HELP scraper_go_browser_pool_browsers Running pooled browsers
+# TYPE scraper_go_browser_pool_browsers gauge
+scraper_go_browser_pool_browsers %d
+# HELP scraper_go_browser_pool_tabs_in_use Tabs currently used by tasks
+# TYPE scraper_go_browser_pool_tabs_in_use gauge
+scraper_go_browser_pool_tabs_in_use %d
+# HELP scraper_go_browser_pool_tasks_total Tasks served by pooled browsers
+# TYPE scraper_go_browser_pool_tasks_total counter
+scraper_go_browser_pool_tasks_total %d
+# HELP scraper_go_browser_pool_launches_total Browsers launched
+# TYPE scraper_go_browser_pool_launches_total counter
+scraper_go_browser_pool_launches_total %d
+# HELP scraper_go_browser_pool_recycles_total Browsers recycled after too many tasks or too much memory
+# TYPE scraper_go_browser_pool_recycles_total counter
+scraper_go_browser_pool_recycles_total %d
+# HELP scraper_go_browser_pool_crashes_total Browsers that exited unexpectedly
+# TYPE scraper_go_browser_pool_crashes_total counter
+scraper_go_browser_pool_crashes_total %d
+# HELP scraper_go_browser_pool_waits_total Tasks that waited for a free tab
+# TYPE scraper_go_browser_pool_waits_total counter
+scraper_go_browser_pool_waits_total %d
+# HELP scraper_go_browser_pool_wait_seconds_total Time tasks spent waiting for a free tab
+# TYPE scraper_go_browser_pool_wait_seconds_total counter
+scraper_go_browser_pool_wait_seconds_total %.3f
+`, len(p.browsers), tabs, p.tasks, p.launches, p.recycles, p.crashes, p.waits, p.waitTotal.Seconds())
+	p.mu.Unlock()
+
+	if len(ids) > 0 {
+		fmt.Fprintln(w, "# HELP scraper_go_browser_memory_bytes Resident memory of a pooled browser and its child processes, sampled every 10 seconds")
+		fmt.Fprintln(w, "# TYPE scraper_go_browser_memory_bytes gauge")
+		for i, id := range ids {
+			fmt.Fprintf(w, "scraper_go_browser_memory_bytes{browser=\"%d\"} %d\n", id, memory[i])
+		}
+	}
+}
+
+// reserveTab picks a browser with a free tab and matching stealth flags,
+// launching or waiting as needed. When the pool is full of idle browsers
+// launched with the other flags, one of them is closed to make room.
+func (p *BrowserPool) reserveTab(stealth bool) (*pooledBrowser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.closed {
+			return nil, errBrowserPoolClosed
+		}
+
+		var best, idle *pooledBrowser
+		active := p.launching
+		for _, browser := range p.browsers {
+			if browser.retiring {
+				continue
+			}
+			active++
+			if browser.stealth != stealth {
+				if browser.tabs == 0 {
+					idle = browser
+				}
+				continue
+			}
+			if browser.tabs >= p.maxTabs() {
+				continue
+			}
+			if best == nil || browser.tabs < best.tabs {
+				best = browser
+			}
+		}
+		// Prefer a new browser over doubling up while the pool has room
+		if best != nil && (best.tabs == 0 || active >= p.size()) {
+			best.tabs++
+			return best, nil
+		}
+
+		if active >= p.size() && idle != nil {
+			idle.closed = true
+			p.remove(idle)
+			p.mu.Unlock()
+			p.logger.WithField("browser", idle.id).Debug("Closing idle browser to launch one with other flags")
+			idle.cancel()
+			idle.allocCancel()
+			p.mu.Lock()
+			continue
+		}
+
+		if active < p.size() {
+			p.launching++
+			p.mu.Unlock()
+			browser, err := p.launch(stealth)
+			p.mu.Lock()
+			p.launching--
+			if err != nil {
+				p.available.Broadcast()
+				return nil, err
+			}
+			if p.closed {
+				browser.cancel()
+				browser.allocCancel()
+				return nil, errBrowserPoolClosed
+			}
+			browser.tabs++
+			p.browsers = append(p.browsers, browser)
+			return browser, nil
+		}
+
+		p.available.Wait()
+	}
+}
+
+// launch starts a Chrome process and watches it for crashes
+func (p *BrowserPool) launch(stealth bool) (*pooledBrowser, error) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.NoFirstRun,
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.NoSandbox,
+		chromedp.DisableGPU,
+		chromedp.Flag("disable-dev-shm-usage", true),
+	)
+	if stealth {
+		opts = append(opts,
+			chromedp.Flag("disable-web-security", true),
+			chromedp.Flag("disable-features", "VizDisplayCompositor"),
+			chromedp.Flag("disable-blink-features", "AutomationControlled"),
+		)
+	}
+
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, cancel := chromedp.NewContext(allocCtx, chromedp.WithLogf(p.logger.Debugf))
+	if err := chromedp.Run(ctx); err != nil {
+		cancel()
+		allocCancel()
+		return nil, fmt.Errorf("failed to launch browser: %w", err)
+	}
+
+	p.mu.Lock()
+	p.nextID++
+	p.launches++
+	browser := &pooledBrowser{
+		id:          p.nextID,
+		ctx:         ctx,
+		cancel:      cancel,
+		allocCancel: allocCancel,
+		startedAt:   time.Now(),
+		stealth:     stealth,
+	}
+	p.mu.Unlock()
+
+	chromeBrowser := chromedp.FromContext(ctx).Browser
+	if process := chromeBrowser.Process(); process != nil {
+		browser.pid = process.Pid
+	}
+	go p.watch(browser, chromeBrowser.LostConnection)
+	go p.sampleMemory(browser)
+
+	p.logger.WithFields(logrus.Fields{
+		"browser": browser.id,
+		"pid":     browser.pid,
+	}).Info("Launched pooled browser")
+
+	return browser, nil
+}
+
+// watch removes a browser from the pool when its connection is lost. Tasks
+// still using it fail with their own errors and release their leases.
+func (p *BrowserPool) watch(browser *pooledBrowser, lost <-chan struct{}) {
+	<-lost
+
+	p.mu.Lock()
+	crashed := !browser.closed
+	if crashed {
+		browser.closed = true
+		p.crashes++
+		p.remove(browser)
+	}
+	p.available.Broadcast()
+	p.mu.Unlock()
+
+	if crashed {
+		p.logger.WithField("browser", browser.id).Warn("Pooled browser exited unexpectedly, it will be replaced")
+		browser.cancel()
+		browser.allocCancel()
+	}
+}
+
+// release returns a tab to the pool and retires its browser when due
+func (p *BrowserPool) release(browser *pooledBrowser) {
+	maxMemory := int64(p.config.BrowserMaxMemoryMB) * 1024 * 1024
+
+	p.mu.Lock()
+	browser.tabs--
+	browser.tasks++
+	p.tasks++
+
+	if !browser.retiring && !browser.closed {
+		maxTasks := p.config.BrowserRecycleAfter
+		if maxTasks > 0 && browser.tasks >= maxTasks {
+			browser.retiring = true
+		} else if maxMemory > 0 && browser.rss > maxMemory {
+			browser.retiring = true
+		}
+		if browser.retiring {
+			p.recycles++
+			p.logger.WithFields(logrus.Fields{
+				"browser": browser.id,
+				"tasks":   browser.tasks,
+				"age":     time.Since(browser.startedAt).Round(time.Second).String(),
+			}).Info("Recycling pooled browser")
+		}
+	}
+
+	shutdown := browser.retiring && browser.tabs == 0 && !browser.closed
+	if shutdown {
+		browser.closed = true
+		p.remove(browser)
+	}
+	p.available.Broadcast()
+	p.mu.Unlock()
+
+	if shutdown {
+		browser.cancel()
+		browser.allocCancel()
+	}
+}
+
+// sampleMemory measures the memory of a browser's process tree until the
+// browser is closed, so that releases and metrics read the last sample
+// instead of walking /proc
+func (p *BrowserPool) sampleMemory(browser *pooledBrowser) {
+	ticker := time.NewTicker(memorySampleInterval)
+	defer ticker.Stop()
+
+	for {
+		rss := processTreeRSS(browser.pid)
+		p.mu.Lock()
+		browser.rss = rss
+		p.mu.Unlock()
+
+		select {
+		case <-browser.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// remove drops a browser from the pool; the caller holds the lock
+func (p *BrowserPool) remove(browser *pooledBrowser) {
+	for i, candidate := range p.browsers {
+		if candidate == browser {
+			p.browsers = append(p.browsers[:i], p.browsers[i+1:]...)
+			return
+		}
+	}
+}
+
+// size returns the maximum number of browsers
+func (p *BrowserPool) size() int {
+	if p.config.BrowserPoolSize > 0 {
+		return p.config.BrowserPoolSize
+	}
+	return 1
+}
+
+// maxTabs returns the maximum number of tabs per browser
+func (p *BrowserPool) maxTabs() int {
+	if p.config.BrowserMaxTabs > 0 {
+		return p.config.BrowserMaxTabs
+	}
+	return 1
+}
+
+// processTreeRSS returns the resident memory in bytes of a process and all
+// of its descendants, read from /proc. It returns 0 where /proc is missing.
+func processTreeRSS(pid int) int64 {
+	if pid <= 0 {
+		return 0
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The fields after the parenthesised command are: state ppid ...
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if parent, err := strconv.Atoi(fields[1]); err == nil {
+			children[parent] = append(children[parent], child)
+		}
+	}
+
+	pageSize := int64(os.Getpagesize())
+	var total int64
+	queue := []int{pid}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = append(queue[1:], children[current]...)
+
+		statm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(current), "statm"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(statm))
+		if len(fields) < 2 {
+			continue
+		}
+		if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			total += pages * pageSize
+		}
+	}
+	return total
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -40,6 +41,7 @@ type Config struct {
 	ProxyList           []string
 	UseProxyRotation    bool
 	CurrentProxyIndex   int
+	proxyMu             sync.Mutex
 
 	// Retry Configuration
 	MaxRetries int
@@ -49,6 +51,22 @@ type Config struct {
 	DefaultTimeout    int
 	DefaultUserAgent  string
 	DefaultMaxRetries int
+	RobotsCacheTTL    time.Duration
+	AutoEngineTTL     time.Duration
+	ArchiveLocalDir   string // directory reextract tasks may read local archives from
+
+	// Politeness Configuration, applied per host across all workers
+	HostMaxConcurrency int
+	HostMinDelay       time.Duration
+	HostRPS            float64
+	HostBurst          int
+	HostLimits         map[string]HostLimit // per-domain overrides
+
+	// Browser Pool Configuration
+	BrowserPoolSize     int
+	BrowserMaxTabs      int
+	BrowserRecycleAfter int
+	BrowserMaxMemoryMB  int
 	
 	// Output Format Configuration
 	DefaultOutputFormat string
@@ -63,6 +81,15 @@ type Config struct {
 	DefaultViewportHeight int
 }
 
+// HostLimit is the politeness limit applied to requests to one host. Zero
+// values mean no limit; in HOST_LIMITS overrides they inherit the global value.
+type HostLimit struct {
+	MaxConcurrency int           // concurrent requests in flight
+	MinDelay       time.Duration // delay between request starts
+	RPS            float64       // token bucket refill rate
+	Burst          int           // token bucket size
+}
+
 // LoadConfig loads configuration from environment variables
 func LoadConfig() (*Config, error) {
 	// Load .env file if it exists
@@ -88,6 +115,19 @@ func LoadConfig() (*Config, error) {
 		DefaultTimeout:     getEnvAsInt("DEFAULT_TIMEOUT", 30),
 		DefaultUserAgent:   getEnv("DEFAULT_USER_AGENT", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"),
 		DefaultMaxRetries:  getEnvAsInt("DEFAULT_MAX_RETRIES", 3),
+		RobotsCacheTTL:     getEnvAsDuration("ROBOTS_CACHE_TTL", time.Hour),
+		AutoEngineTTL:      getEnvAsDuration("AUTO_ENGINE_TTL", 24*time.Hour),
+		ArchiveLocalDir:    getEnv("ARCHIVE_LOCAL_DIR", ""),
+		HostMaxConcurrency: getEnvAsInt("HOST_MAX_CONCURRENCY", 0),
+		HostMinDelay:       getEnvAsDuration("HOST_MIN_DELAY", 0),
+		HostRPS:            getEnvAsFloat("HOST_RPS", 0),
+		HostBurst:          getEnvAsInt("HOST_BURST", 1),
+
+		// Browser pool defaults
+		BrowserPoolSize:     getEnvAsInt("BROWSER_POOL_SIZE", 2),
+		BrowserMaxTabs:      getEnvAsInt("BROWSER_MAX_TABS", 4),
+		BrowserRecycleAfter: getEnvAsInt("BROWSER_RECYCLE_AFTER", 100),
+		BrowserMaxMemoryMB:  getEnvAsInt("BROWSER_MAX_MEMORY_MB", 1024),
 		
 		// Output format defaults
 		DefaultOutputFormat: getEnv("DEFAULT_OUTPUT_FORMAT", "json"),
@@ -111,6 +151,9 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	// Parse per-domain politeness overrides
+	config.HostLimits = parseHostLimits(getEnv("HOST_LIMITS", ""))
+
 	// Validate required configuration
 	if err := config.Validate(); err != nil {
 		return nil, err
@@ -142,15 +185,113 @@ func (c *Config) Validate() error {
 
 // GetNextProxy returns the next proxy in rotation
 func (c *Config) GetNextProxy() string {
-	if !c.UseProxyRotation || len(c.ProxyList) == 0 {
+	if !c.UseProxyRotation {
 		return ""
 	}
+	return c.NextPoolProxy()
+}
+
+// NextPoolProxy returns the next proxy of PROXY_LIST even when rotation is
+// disabled, for switching proxies between retry attempts. It is safe for
+// concurrent use by the workers.
+func (c *Config) NextPoolProxy() string {
+	c.proxyMu.Lock()
+	defer c.proxyMu.Unlock()
 
-	proxy := c.ProxyList[c.CurrentProxyIndex]
+	if len(c.ProxyList) == 0 {
+		return ""
+	}
+
+	proxy := c.ProxyList[c.CurrentProxyIndex%len(c.ProxyList)]
 	c.CurrentProxyIndex = (c.CurrentProxyIndex + 1) % len(c.ProxyList)
 	return proxy
 }
 
+// HostLimitFor returns the politeness limit for a host: the global limit
+// with any fields set by the most specific matching HOST_LIMITS domain
+// overriding it. A domain matches itself and its subdomains.
+func (c *Config) HostLimitFor(host string) HostLimit {
+	limit := HostLimit{
+		MaxConcurrency: c.HostMaxConcurrency,
+		MinDelay:       c.HostMinDelay,
+		RPS:            c.HostRPS,
+		Burst:          c.HostBurst,
+	}
+
+	host = strings.ToLower(host)
+	match := ""
+	for domain := range c.HostLimits {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(match) {
+			match = domain
+		}
+	}
+	if match == "" {
+		return limit
+	}
+
+	override := c.HostLimits[match]
+	if override.MaxConcurrency != 0 {
+		limit.MaxConcurrency = override.MaxConcurrency
+	}
+	if override.MinDelay != 0 {
+		limit.MinDelay = override.MinDelay
+	}
+	if override.RPS != 0 {
+		limit.RPS = override.RPS
+	}
+	if override.Burst != 0 {
+		limit.Burst = override.Burst
+	}
+	return limit
+}
+
+// parseHostLimits parses per-domain limits of the form
+// "shop.example.com=concurrency:1,delay:2s,rps:0.5,burst:2;api.example.com=rps:10".
+// Invalid entries are logged and skipped.
+func parseHostLimits(value string) map[string]HostLimit {
+	limits := make(map[string]HostLimit)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, settings, ok := strings.Cut(entry, "=")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if !ok || domain == "" {
+			logrus.WithField("entry", entry).Warn("Ignoring invalid HOST_LIMITS entry")
+			continue
+		}
+
+		var limit HostLimit
+		valid := true
+		for _, setting := range strings.Split(settings, ",") {
+			key, raw, _ := strings.Cut(strings.TrimSpace(setting), ":")
+			var err error
+			switch strings.TrimSpace(key) {
+			case "concurrency":
+				limit.MaxConcurrency, err = strconv.Atoi(strings.TrimSpace(raw))
+			case "delay":
+				limit.MinDelay, err = time.ParseDuration(strings.TrimSpace(raw))
+			case "rps":
+				limit.RPS, err = strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			case "burst":
+				limit.Burst, err = strconv.Atoi(strings.TrimSpace(raw))
+			default:
+				err = fmt.Errorf("unknown setting %q", key)
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("entry", entry).Warn("Ignoring invalid HOST_LIMITS entry")
+				valid = false
+				break
+			}
+		}
+		if valid {
+			limits[domain] = limit
+		}
+	}
+	return limits
+}
+
 // Helper functions
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -168,6 +309,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+const (
+	defaultCrawlMaxDepth = 2
+	defaultCrawlMaxPages = 100
+)
+
+// CrawlOutput holds one record per crawled page together with metadata
+// describing the crawl
+type CrawlOutput struct {
+	Records  []map[string]interface{}
+	Metadata map[string]interface{}
+}
+
+// Crawl starts at the task URL and follows links matching the crawl options,
+// applying the task schema to every fetched page. Each page produces a
+// record with its url, depth and data, or the error that prevented
+// extraction. Crawls always use Colly.
+func (se *ScraperEngine) Crawl(task *models.TaskMessage) (*CrawlOutput, error) {
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"url":     task.URL,
+	}).Info("Starting crawl")
+
+	startURL, err := url.Parse(task.URL)
+	if err != nil || startURL.Host == "" {
+		return nil, fmt.Errorf("%w: invalid URL: %s", errInvalidTask, task.URL)
+	}
+
+	var options models.CrawlOptions
+	if task.Options.Crawl != nil {
+		options = *task.Options.Crawl
+	}
+	maxDepth := options.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultCrawlMaxDepth
+	}
+	maxPages := options.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultCrawlMaxPages
+	}
+	domains := options.AllowedDomains
+	if len(domains) == 0 {
+		domains = []string{startURL.Hostname()}
+	}
+
+	allow, err := compilePatterns(options.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid allow pattern: %w", errInvalidTask, err)
+	}
+	deny, err := compilePatterns(options.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid deny pattern: %w", errInvalidTask, err)
+	}
+
+	// Colly counts the start URL as depth 1
+	c := se.newCollector(task, colly.MaxDepth(maxDepth+1), colly.AllowedDomains(domains...))
+
+	output := &CrawlOutput{Records: []map[string]interface{}{}}
+	requested := 0
+	failed := 0
+	extractionErrors := 0
+	robotsSkipped := 0
+	var firstErr error
+
+	// The collector is synchronous, so the callbacks never run concurrently
+	c.OnRequest(func(r *colly.Request) {
+		if requested >= maxPages {
+			r.Abort()
+			return
+		}
+		if err := se.checkRobots(task, r.URL.String()); err != nil {
+			se.logger.WithError(err).WithField("url", r.URL.String()).Debug("Skipping page")
+			robotsSkipped++
+			r.Abort()
+			return
+		}
+		requested++
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		record := map[string]interface{}{
+			"url":        r.Request.URL.String(),
+			"depth":      r.Request.Depth - 1,
+			"fetched_at": time.Now().UTC().Format(time.RFC3339),
+		}
+
+		pageOutput, err := se.extractFromPage(task, newFetchedPage(r))
+		if pageOutput != nil {
+			record["data"] = pageOutput.Data
+			if missing, ok := pageOutput.Metadata["missing_fields"]; ok {
+				record["missing_fields"] = missing
+			}
+		}
+		if err != nil {
+			record["error"] = err.Error()
+			extractionErrors++
+		}
+
+		output.Records = append(output.Records, record)
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		se.logger.WithError(err).WithFields(logrus.Fields{
+			"task_id": task.TaskID,
+			"url":     r.Request.URL.String(),
+			"status":  r.StatusCode,
+		}).Warn("Crawl request failed")
+
+		if r.StatusCode >= 400 {
+			err = newHTTPStatusError(r)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+		output.Records = append(output.Records, map[string]interface{}{
+			"url":        r.Request.URL.String(),
+			"depth":      r.Request.Depth - 1,
+			"fetched_at": time.Now().UTC().Format(time.RFC3339),
+			"error":      err.Error(),
+		})
+	})
+
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		link, err := url.Parse(e.Request.AbsoluteURL(e.Attr("href")))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+			return
+		}
+		link.Fragment = ""
+		if !crawlAllowed(link.String(), allow, deny) {
+			return
+		}
+
+		// Already visited, too deep and foreign domain errors are expected
+		if err := e.Request.Visit(link.String()); err != nil {
+			se.logger.WithError(err).WithField("url", link.String()).Debug("Skipping link")
+		}
+	})
+
+	if task.Options.RespectRobots {
+		if _, err := se.robots.Allowed(startURL.String(), se.userAgent(task)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Visit(startURL.String()); err != nil && !errors.Is(err, colly.ErrAlreadyVisited) {
+		return nil, fmt.Errorf("failed to visit URL: %w", err)
+	}
+
+	if len(output.Records) == failed {
+		if firstErr != nil {
+			return nil, fmt.Errorf("crawl fetched no pages from %s: %w", task.URL, firstErr)
+		}
+		return nil, fmt.Errorf("crawl fetched no pages from %s", task.URL)
+	}
+
+	output.Metadata = map[string]interface{}{
+		"pages":             len(output.Records),
+		"failed_pages":      failed,
+		"extraction_errors": extractionErrors,
+		"robots_skipped":    robotsSkipped,
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"pages":   len(output.Records),
+		"failed":  failed,
+	}).Info("Crawl completed")
+
+	return output, nil
+}
+
+// compilePatterns compiles a list of regular expressions
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// crawlAllowed reports whether a link matches one of the allow patterns
+// (when any are given) and none of the deny patterns
+func crawlAllowed(link string, allow, deny []*regexp.Regexp) bool {
+	for _, re := range deny {
+		if re.MatchString(link) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, re := range allow {
+		if re.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+
+	"scraper-go/models"
+)
+
+// Sentinel errors that mark the error code of a failure. errSelectorMiss and
+// errRobotsDisallowed are declared next to the code that returns them.
+var (
+	errInvalidTask   = errors.New("invalid_task")
+	errCaptchaFailed = errors.New("captcha_failed")
+	errUploadFailed  = errors.New("upload_failed")
+)
+
+// errorInfo is the classification of a task failure reported to the API
+type errorInfo struct {
+	Code       models.ErrorCode
+	Retryable  bool
+	HTTPStatus int
+}
+
+// classifyError derives the error code, retryable flag and HTTP status of an
+// error from the sentinels and error types in its chain
+func classifyError(err error) errorInfo {
+	info := errorInfo{Code: models.ErrorCodeInternal, Retryable: retryableError(err)}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		info.HTTPStatus = statusErr.StatusCode
+	}
+	var blocked *blockedError
+
+	switch {
+	case errors.Is(err, errInvalidTask):
+		info.Code = models.ErrorCodeInvalidTask
+	case errors.Is(err, errUploadFailed):
+		info.Code = models.ErrorCodeUploadFailed
+	case errors.Is(err, errRobotsDisallowed):
+		info.Code = models.ErrorCodeRobotsDisallowed
+	case errors.Is(err, errCaptchaFailed):
+		info.Code = models.ErrorCodeCaptchaFailed
+	case errors.Is(err, errSelectorMiss):
+		info.Code = models.ErrorCodeSelectorMiss
+	case errors.As(err, &blocked):
+		info.Code = models.ErrorCodeBlocked
+	case statusErr != nil:
+		info.Code = models.ErrorCodeHTTPStatus
+	case isTimeout(err):
+		info.Code = models.ErrorCodeTimeout
+	case isNetworkError(err):
+		info.Code = models.ErrorCodeNetwork
+	}
+	return info
+}
+
+// isTimeout reports whether an error is a request or browser timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isNetworkError reports whether an error comes from the connection rather
+// than from the response
+func isNetworkError(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var urlErr *url.Error
+	return errors.As(err, &opErr) ||
+		errors.As(err, &dnsErr) ||
+		errors.As(err, &urlErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// recordError marks a result as failed with the error and its classification
+func recordError(result *models.ScrapingResult, err error) {
+	info := classifyError(err)
+	result.Status = models.TaskStatusFailed
+	result.Error = err.Error()
+	result.ErrorCode = info.Code
+	result.Retryable = info.Retryable
+	result.HTTPStatus = info.HTTPStatus
+}
+
+// recordWarning keeps a completed result completed while reporting a failure
+// that did not lose its data, such as an artifact upload
+func recordWarning(result *models.ScrapingResult, err error) {
+	result.Warning = err.Error()
+	result.WarningCode = classifyError(err).Code
+}
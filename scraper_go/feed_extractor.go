@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// extractFromXML extracts data from an XML response. Without a schema (or
+// with response_format "feed") RSS and Atom feeds are returned in a
+// normalized form; otherwise the schema is evaluated with XPath.
+func (se *ScraperEngine) extractFromXML(body []byte, schema map[string]interface{}, normalizeFeed bool) (map[string]interface{}, *extractionReport, error) {
+	root, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	if normalizeFeed || len(schema) == 0 {
+		feed, err := normalizeFeedDocument(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		return feed, newExtractionReport(), nil
+	}
+
+	return se.extractDataFromXML(root, schema)
+}
+
+// extractDataFromXML evaluates a schema against an XML document. Every
+// field selector ("selector" or "xpath") is an XPath expression relative to
+// the current node; types are text, xml, attr, list, object and items.
+func (se *ScraperEngine) extractDataFromXML(root *xmlquery.Node, schema map[string]interface{}) (map[string]interface{}, *extractionReport, error) {
+	report := newExtractionReport()
+	result := se.extractXMLFields(root, schema, "", report)
+	return result, report, report.err()
+}
+
+// extractXMLFields evaluates a schema relative to an XML node
+func (se *ScraperEngine) extractXMLFields(scope *xmlquery.Node, schema map[string]interface{}, path string, report *extractionReport) map[string]interface{} {
+	return se.extractSchema(schema, path, report, func(fieldName string, config map[string]interface{}, fieldPath string) (interface{}, error) {
+		return se.extractXMLField(scope, fieldName, config, fieldPath, report)
+	})
+}
+
+// extractXMLField extracts a single field from an XML node
+func (se *ScraperEngine) extractXMLField(scope *xmlquery.Node, fieldName string, config map[string]interface{}, path string, report *extractionReport) (interface{}, error) {
+	expr, ok := config["xpath"].(string)
+	if !ok {
+		expr, ok = config["selector"].(string)
+	}
+	if !ok {
+		return nil, fmt.Errorf("xpath is required for field %s", fieldName)
+	}
+
+	fieldType, ok := config["type"].(string)
+	if !ok {
+		fieldType = "text"
+	}
+
+	nodes, err := xmlquery.QueryAll(scope, expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XPath expression %s: %w", expr, err)
+	}
+
+	var result interface{}
+	switch fieldType {
+	case "list":
+		values := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			if text := strings.TrimSpace(node.InnerText()); text != "" {
+				values = append(values, text)
+			}
+		}
+		result = values
+	case "items":
+		fields, ok := config["fields"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("fields is required for items extraction")
+		}
+		items := make([]map[string]interface{}, 0, len(nodes))
+		for _, node := range nodes {
+			items = append(items, se.extractXMLFields(node, fields, path+"[]", report))
+		}
+		result = items
+	case "text", "xml", "attr", "object":
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("%w: no nodes found for XPath: %s", errSelectorMiss, expr)
+		}
+		node := nodes[0]
+
+		switch fieldType {
+		case "text":
+			result = strings.TrimSpace(node.InnerText())
+		case "xml":
+			result = node.OutputXML(false)
+		case "attr":
+			attrName, ok := config["attr"].(string)
+			if !ok {
+				return nil, fmt.Errorf("attr is required for attribute extraction")
+			}
+			value, exists := xmlAttr(node, attrName)
+			if !exists {
+				return nil, fmt.Errorf("%w: attribute %s not found", errSelectorMiss, attrName)
+			}
+			result = value
+		case "object":
+			fields, ok := config["fields"].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("fields is required for object extraction")
+			}
+			result = se.extractXMLFields(node, fields, path, report)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported field type for XML responses: %s", fieldType)
+	}
+
+	return se.postProcessField(result, config)
+}
+
+// xmlAttr looks up an attribute by local or prefixed name
+func xmlAttr(node *xmlquery.Node, name string) (string, bool) {
+	for _, attr := range node.Attr {
+		qualified := attr.Name.Local
+		if attr.Name.Space != "" {
+			qualified = attr.Name.Space + ":" + attr.Name.Local
+		}
+		if name == qualified || name == attr.Name.Local {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// normalizeFeedDocument converts an RSS 2.0, RSS 1.0 (RDF) or Atom document
+// into {"feed": {...}, "items": [...]} where every item has title, link,
+// published, author and content
+func normalizeFeedDocument(root *xmlquery.Node) (map[string]interface{}, error) {
+	document := firstElement(root)
+	if document == nil {
+		return nil, fmt.Errorf("empty XML document")
+	}
+
+	var channel *xmlquery.Node
+	var entries []*xmlquery.Node
+	var normalize func(*xmlquery.Node) map[string]interface{}
+
+	switch strings.ToLower(document.Data) {
+	case "rss":
+		channel = childElement(document, "channel")
+		if channel == nil {
+			return nil, fmt.Errorf("RSS feed has no channel")
+		}
+		entries = childElements(channel, "item")
+		normalize = normalizeRSSItem
+	case "rdf":
+		channel = childElement(document, "channel")
+		entries = childElements(document, "item")
+		normalize = normalizeRSSItem
+	case "feed":
+		channel = document
+		entries = childElements(document, "entry")
+		normalize = normalizeAtomEntry
+	default:
+		return nil, fmt.Errorf("XML document is not an RSS or Atom feed (root element %s)", document.Data)
+	}
+
+	items := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, normalize(entry))
+	}
+
+	feed := map[string]interface{}{}
+	if channel != nil {
+		feed["title"] = childText(channel, "title")
+		feed["description"] = firstNonEmpty(childText(channel, "description"), childText(channel, "subtitle"))
+		feed["updated"] = normalizeFeedDate(firstNonEmpty(childText(channel, "lastBuildDate"), childText(channel, "pubDate"), childText(channel, "updated")))
+		if strings.EqualFold(document.Data, "feed") {
+			feed["link"] = atomLink(channel)
+		} else {
+			feed["link"] = childText(channel, "link")
+		}
+	}
+
+	return map[string]interface{}{
+		"feed":  feed,
+		"items": items,
+	}, nil
+}
+
+// normalizeRSSItem converts an RSS <item> into the normalized item shape
+func normalizeRSSItem(item *xmlquery.Node) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        firstNonEmpty(childText(item, "guid"), childText(item, "link")),
+		"title":     childText(item, "title"),
+		"link":      childText(item, "link"),
+		"published": normalizeFeedDate(firstNonEmpty(childText(item, "pubDate"), childText(item, "dc:date"))),
+		"author":    firstNonEmpty(childText(item, "author"), childText(item, "dc:creator")),
+		"content":   firstNonEmpty(childText(item, "content:encoded"), childText(item, "description")),
+	}
+}
+
+// normalizeAtomEntry converts an Atom <entry> into the normalized item shape
+func normalizeAtomEntry(entry *xmlquery.Node) map[string]interface{} {
+	author := ""
+	if authorNode := childElement(entry, "author"); authorNode != nil {
+		author = childText(authorNode, "name")
+	}
+
+	return map[string]interface{}{
+		"id":        childText(entry, "id"),
+		"title":     childText(entry, "title"),
+		"link":      atomLink(entry),
+		"published": normalizeFeedDate(firstNonEmpty(childText(entry, "published"), childText(entry, "updated"))),
+		"author":    author,
+		"content":   firstNonEmpty(childText(entry, "content"), childText(entry, "summary")),
+	}
+}
+
+// atomLink returns the alternate link of an Atom feed or entry
+func atomLink(node *xmlquery.Node) string {
+	fallback := ""
+	for _, link := range childElements(node, "link") {
+		href, _ := xmlAttr(link, "href")
+		rel, _ := xmlAttr(link, "rel")
+		if rel == "" || rel == "alternate" {
+			return href
+		}
+		if fallback == "" {
+			fallback = href
+		}
+	}
+	return fallback
+}
+
+// feedDateLayouts are the date formats seen in RSS and Atom feeds
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	time.RFC822Z,
+	time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// normalizeFeedDate converts feed dates to RFC 3339, keeping unknown
+// formats unchanged
+func normalizeFeedDate(value string) string {
+	for _, layout := range feedDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.Format(time.RFC3339)
+		}
+	}
+	return value
+}
+
+// firstElement returns the first element child of a node
+func firstElement(node *xmlquery.Node) *xmlquery.Node {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// childElements returns the element children of node with the given name,
+// either "local" or "prefix:local"
+func childElements(node *xmlquery.Node, name string) []*xmlquery.Node {
+	var children []*xmlquery.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xmlquery.ElementNode {
+			continue
+		}
+		qualified := child.Data
+		if child.Prefix != "" {
+			qualified = child.Prefix + ":" + child.Data
+		}
+		if strings.EqualFold(qualified, name) || (!strings.Contains(name, ":") && child.Prefix == "" && strings.EqualFold(child.Data, name)) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// childElement returns the first element child with the given name
+func childElement(node *xmlquery.Node, name string) *xmlquery.Node {
+	if children := childElements(node, name); len(children) > 0 {
+		return children[0]
+	}
+	return nil
+}
+
+// childText returns the trimmed text of the first child with the given name
+func childText(node *xmlquery.Node, name string) string {
+	if child := childElement(node, name); child != nil {
+		return strings.TrimSpace(child.InnerText())
+	}
+	return ""
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
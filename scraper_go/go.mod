@@ -3,21 +3,24 @@ module scraper-go
 go 1.21
 
 require (
+	github.com/PuerkitoBio/goquery v1.8.1
+	github.com/antchfx/htmlquery v1.3.0
+	github.com/antchfx/xmlquery v1.3.18
 	github.com/aws/aws-sdk-go v1.48.0
+	github.com/chromedp/cdproto v0.0.0-20231011050154-1d073bb38998
+	github.com/chromedp/chromedp v0.9.3
 	github.com/gocolly/colly/v2 v2.1.0
-	github.com/PuerkitoBio/goquery v1.8.1
-	github.com/sirupsen/logrus v1.9.3
-	github.com/joho/godotenv v1.4.0
 	github.com/gorilla/websocket v1.5.1
-	github.com/chromedp/chromedp v0.9.3
+	github.com/joho/godotenv v1.4.0
+	github.com/sirupsen/logrus v1.9.3
+	github.com/temoto/robotstxt v1.1.2
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.1 // indirect
-	github.com/antchfx/htmlquery v1.3.0 // indirect
-	github.com/antchfx/xmlquery v1.3.18 // indirect
 	github.com/antchfx/xpath v1.2.4 // indirect
-	github.com/chromedp/cdproto v0.0.0-20231011050154-1d073bb38998 // indirect
 	github.com/chromedp/sysutil v1.0.0 // indirect
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
@@ -25,14 +28,13 @@ require (
 	github.com/gobwas/ws v1.3.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/klauspost/compress v1.17.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
-	github.com/temoto/robotstxt v1.1.2 // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
-	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -16,6 +16,7 @@ type HealthChecker struct {
 	reporter   *Reporter
 	logger     *logrus.Logger
 	httpServer *http.Server
+	sources    []MetricsSource
 }
 
 // NewHealthChecker creates a new health checker
@@ -33,6 +34,11 @@ func NewHealthChecker(cfg *config.Config, reporter *Reporter) *HealthChecker {
 	}
 }
 
+// RegisterMetrics adds a source whose metrics are served on /metrics
+func (hc *HealthChecker) RegisterMetrics(source MetricsSource) {
+	hc.sources = append(hc.sources, source)
+}
+
 // StartHealthServer starts the health check HTTP server
 func (hc *HealthChecker) StartHealthServer() error {
 	mux := http.NewServeMux()
@@ -94,6 +100,11 @@ scraper_go_info{version="1.0.0"} 1
 # TYPE scraper_go_uptime_seconds counter
 scraper_go_uptime_seconds %d
 `, time.Now().Unix())
+
+	for _, source := range hc.sources {
+		fmt.Fprintln(w)
+		source.WriteMetrics(w)
+	}
 }
 
 // getLogLevel converts string log level to logrus level
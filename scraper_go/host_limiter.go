@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scraper-go/config"
+)
+
+const (
+	// hostIdleTTL is how long an idle host keeps its limiter state
+	hostIdleTTL = 10 * time.Minute
+	// hostMetricsLimit caps the hosts exported with their own metrics label;
+	// the remaining hosts are summed up as host="other"
+	hostMetricsLimit = 20
+)
+
+// MetricsSource writes metrics in the Prometheus text format
+type MetricsSource interface {
+	WriteMetrics(w io.Writer)
+}
+
+// hostState tracks the limiter state of one host
+type hostState struct {
+	limit      config.HostLimit
+	crawlDelay time.Duration // robots.txt Crawl-delay, raising limit.MinDelay
+	slots      chan struct{} // nil when concurrency is unlimited
+	last       time.Time     // start of the previous request
+	tokens     float64
+	refilled   time.Time
+	inFlight   int
+	requests   int64
+	waits      int64
+	waitTotal  time.Duration
+	users      int       // Acquire calls holding or waiting for the state
+	used       time.Time // end of the last request
+}
+
+// HostLimiter enforces per-host politeness limits across all workers of the
+// process: a maximum number of concurrent requests, a minimum delay between
+// request starts and a token bucket rate. Callers over the limit wait for
+// their turn rather than fail. Hosts idle for hostIdleTTL are forgotten.
+type HostLimiter struct {
+	config  *config.Config
+	logger  *logrus.Logger
+	mu      sync.Mutex
+	hosts   map[string]*hostState
+	evicted hostState // counters of forgotten hosts
+	swept   time.Time
+}
+
+// NewHostLimiter creates a host limiter using the configured limits
+func NewHostLimiter(cfg *config.Config, logger *logrus.Logger) *HostLimiter {
+	return &HostLimiter{
+		config: cfg,
+		logger: logger,
+		hosts:  make(map[string]*hostState),
+	}
+}
+
+// Acquire blocks until a request to host is allowed and returns the function
+// that releases its concurrency slot once the request has finished. It
+// returns ctx.Err() when ctx ends while waiting.
+func (hl *HostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	hl.mu.Lock()
+	state := hl.state(host)
+	state.users++
+	hl.mu.Unlock()
+	start := time.Now()
+
+	if state.slots != nil {
+		select {
+		case state.slots <- struct{}{}:
+		case <-ctx.Done():
+			hl.abandon(state, false)
+			return nil, ctx.Err()
+		}
+	}
+	for {
+		hl.mu.Lock()
+		wait := state.reserve(time.Now())
+		hl.mu.Unlock()
+		if wait <= 0 {
+			break
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			hl.abandon(state, true)
+			return nil, err
+		}
+	}
+
+	waited := time.Since(start)
+	hl.mu.Lock()
+	state.requests++
+	state.inFlight++
+	if waited >= time.Millisecond {
+		state.waits++
+		state.waitTotal += waited
+	}
+	hl.mu.Unlock()
+
+	if waited >= time.Millisecond {
+		hl.logger.WithFields(logrus.Fields{
+			"host": host,
+			"wait": waited.String(),
+		}).Debug("Waited for host politeness limit")
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if state.slots != nil {
+				<-state.slots
+			}
+			hl.mu.Lock()
+			state.inFlight--
+			state.users--
+			state.used = time.Now()
+			hl.mu.Unlock()
+		})
+	}, nil
+}
+
+// abandon undoes an Acquire call given up while waiting, freeing its
+// concurrency slot when it holds one
+func (hl *HostLimiter) abandon(state *hostState, holdsSlot bool) {
+	if holdsSlot && state.slots != nil {
+		<-state.slots
+	}
+	hl.mu.Lock()
+	state.users--
+	state.used = time.Now()
+	hl.mu.Unlock()
+}
+
+// SetCrawlDelay sets the robots.txt Crawl-delay of a host. Request starts to
+// the host are then spaced by at least delay, across all workers.
+func (hl *HostLimiter) SetCrawlDelay(host string, delay time.Duration) {
+	hl.mu.Lock()
+	hl.state(strings.ToLower(host)).crawlDelay = delay
+	hl.mu.Unlock()
+}
+
+// Transport wraps an HTTP transport so that every request it sends holds a
+// host slot until its response body is closed
+func (hl *HostLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	return &limitedTransport{base: base, limiter: hl}
+}
+
+// WriteMetrics writes per-host request and wait metrics. The busiest
+// hostMetricsLimit hosts get their own label, all others are summed up as
+// host="other".
+func (hl *HostLimiter) WriteMetrics(w io.Writer) {
+	hl.mu.Lock()
+	hosts := make([]string, 0, len(hl.hosts))
+	for host := range hl.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := hl.hosts[hosts[i]], hl.hosts[hosts[j]]
+		if a.requests != b.requests {
+			return a.requests > b.requests
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	other := hl.evicted
+	labels := make([]string, 0, hostMetricsLimit+1)
+	states := make([]hostState, 0, hostMetricsLimit+1)
+	for i, host := range hosts {
+		state := hl.hosts[host]
+		if i < hostMetricsLimit {
+			labels = append(labels, host)
+			states = append(states, *state)
+			continue
+		}
+		other.add(state)
+	}
+	hl.mu.Unlock()
+
+	if len(hosts) > hostMetricsLimit || other.requests > 0 {
+		labels = append(labels, "other")
+		states = append(states, other)
+	}
+
+	fmt.Fprintln(w, "# HELP scraper_go_host_requests_total Requests started per host")
+	fmt.Fprintln(w, "# TYPE scraper_go_host_requests_total counter")
+	for i, host := range labels {
+		fmt.Fprintf(w, "scraper_go_host_requests_total{host=%q} %d\n", host, states[i].requests)
+	}
+	fmt.Fprintln(w, "# HELP scraper_go_host_limiter_waits_total Requests delayed by the host limiter")
+	fmt.Fprintln(w, "# TYPE scraper_go_host_limiter_waits_total counter")
+	for i, host := range labels {
+		fmt.Fprintf(w, "scraper_go_host_limiter_waits_total{host=%q} %d\n", host, states[i].waits)
+	}
+	fmt.Fprintln(w, "# HELP scraper_go_host_limiter_wait_seconds_total Time spent waiting on the host limiter")
+	fmt.Fprintln(w, "# TYPE scraper_go_host_limiter_wait_seconds_total counter")
+	for i, host := range labels {
+		fmt.Fprintf(w, "scraper_go_host_limiter_wait_seconds_total{host=%q} %.3f\n", host, states[i].waitTotal.Seconds())
+	}
+	fmt.Fprintln(w, "# HELP scraper_go_host_in_flight Requests currently in flight per host")
+	fmt.Fprintln(w, "# TYPE scraper_go_host_in_flight gauge")
+	for i, host := range labels {
+		fmt.Fprintf(w, "scraper_go_host_in_flight{host=%q} %d\n", host, states[i].inFlight)
+	}
+}
+
+// state returns the limiter state of a host, creating it on first use. It
+// must be called with hl.mu held.
+func (hl *HostLimiter) state(host string) *hostState {
+	now := time.Now()
+	if now.Sub(hl.swept) >= hostIdleTTL {
+		hl.evictIdle(now)
+	}
+
+	if state, ok := hl.hosts[host]; ok {
+		return state
+	}
+
+	limit := hl.config.HostLimitFor(host)
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	state := &hostState{
+		limit:    limit,
+		tokens:   float64(limit.Burst),
+		refilled: now,
+		used:     now,
+	}
+	if limit.MaxConcurrency > 0 {
+		state.slots = make(chan struct{}, limit.MaxConcurrency)
+	}
+	hl.hosts[host] = state
+	return state
+}
+
+// evictIdle forgets the hosts without requests in flight or waiting that
+// have been idle for hostIdleTTL, keeping their counters in hl.evicted. It
+// must be called with hl.mu held.
+func (hl *HostLimiter) evictIdle(now time.Time) {
+	for host, state := range hl.hosts {
+		if state.users == 0 && now.Sub(state.used) >= hostIdleTTL {
+			hl.evicted.add(state)
+			delete(hl.hosts, host)
+		}
+	}
+	hl.swept = now
+}
+
+// add sums up the request counters of another host
+func (s *hostState) add(other *hostState) {
+	s.requests += other.requests
+	s.waits += other.waits
+	s.waitTotal += other.waitTotal
+	s.inFlight += other.inFlight
+}
+
+// reserve claims the next request start for the host, returning how long
+// to wait first when the delay or rate limit does not allow one yet
+func (s *hostState) reserve(now time.Time) time.Duration {
+	delay := s.limit.MinDelay
+	if s.crawlDelay > delay {
+		delay = s.crawlDelay
+	}
+	if next := s.last.Add(delay); now.Before(next) {
+		return next.Sub(now)
+	}
+
+	if s.limit.RPS > 0 {
+		s.tokens += now.Sub(s.refilled).Seconds() * s.limit.RPS
+		if s.tokens > float64(s.limit.Burst) {
+			s.tokens = float64(s.limit.Burst)
+		}
+		s.refilled = now
+		if s.tokens < 1 {
+			return time.Duration((1 - s.tokens) / s.limit.RPS * float64(time.Second))
+		}
+		s.tokens--
+	}
+
+	s.last = now
+	return 0
+}
+
+// limitedTransport is an HTTP transport gated by a host limiter
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *HostLimiter
+}
+
+// RoundTrip waits for a host slot and sends the request
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases a host slot when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the body and releases the host slot
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
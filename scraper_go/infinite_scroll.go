@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+const (
+	// defaultScrollIterations bounds the scrolls or clicks of a task
+	defaultScrollIterations = 20
+	// defaultScrollBudget bounds the time spent loading more content
+	defaultScrollBudget = 30 * time.Second
+	// defaultScrollWait is how long new items are waited for per iteration
+	defaultScrollWait = 2 * time.Second
+	// scrollPollInterval is how often the item count is checked
+	scrollPollInterval = 100 * time.Millisecond
+	// scrollCaptureMargin is left of the task timeout for capturing the page
+	scrollCaptureMargin = 2 * time.Second
+)
+
+// validateInfiniteScroll checks the task's infinite scroll options before
+// anything is fetched
+func validateInfiniteScroll(task *models.TaskMessage) error {
+	scroll := task.Options.InfiniteScroll
+	if scroll == nil {
+		return nil
+	}
+	if task.Options.Engine == engineColly {
+		return fmt.Errorf("%w: infinite scroll needs the chrome engine", errInvalidTask)
+	}
+	if scroll.ItemSelector == "" && scroll.LoadMoreSelector == "" {
+		return fmt.Errorf("%w: infinite scroll needs an item_selector or a load_more_selector", errInvalidTask)
+	}
+	return nil
+}
+
+// expandPage loads more content into the page by scrolling to the bottom, or
+// clicking the "load more" element, until no new items appear. It stops at
+// max_iterations, max_items or the time budget, whichever comes first, and
+// returns what it did for the metadata.
+func (se *ScraperEngine) expandPage(ctx context.Context, task *models.TaskMessage) (map[string]interface{}, error) {
+	scroll := task.Options.InfiniteScroll
+
+	maxIterations := scroll.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultScrollIterations
+	}
+	budget := defaultScrollBudget
+	if scroll.TimeBudget > 0 {
+		budget = time.Duration(scroll.TimeBudget) * time.Second
+	}
+	wait := defaultScrollWait
+	if scroll.Wait > 0 {
+		wait = time.Duration(scroll.Wait) * time.Millisecond
+	}
+
+	start := time.Now()
+	deadline := start.Add(budget)
+	if taskDeadline, ok := ctx.Deadline(); ok && taskDeadline.Add(-scrollCaptureMargin).Before(deadline) {
+		deadline = taskDeadline.Add(-scrollCaptureMargin)
+	}
+	mode := "scroll"
+	if scroll.LoadMoreSelector != "" {
+		mode = "load_more"
+	}
+
+	items, err := countItems(ctx, scroll.ItemSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := 0
+	stopReason := "max_iterations"
+	for iterations < maxIterations {
+		if scroll.MaxItems > 0 && items >= scroll.MaxItems {
+			stopReason = "max_items"
+			break
+		}
+		if time.Now().After(deadline) {
+			stopReason = "time_budget"
+			break
+		}
+
+		if mode == "load_more" {
+			var clicked bool
+			if err := chromedp.Run(ctx, chromedp.Evaluate(loadMoreScript(scroll.LoadMoreSelector), &clicked)); err != nil {
+				return nil, fmt.Errorf("failed to click load more: %w", err)
+			}
+			if !clicked {
+				stopReason = "load_more_missing"
+				break
+			}
+		} else if err := chromedp.Run(ctx, chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil)); err != nil {
+			return nil, fmt.Errorf("failed to scroll: %w", err)
+		}
+		iterations++
+
+		loaded, err := waitForItems(ctx, scroll.ItemSelector, items, minTime(deadline, time.Now().Add(wait)))
+		if err != nil {
+			return nil, err
+		}
+		if scroll.ItemSelector != "" && loaded <= items {
+			stopReason = "no_new_items"
+			break
+		}
+		items = loaded
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id":     task.TaskID,
+		"mode":        mode,
+		"iterations":  iterations,
+		"items":       items,
+		"stop_reason": stopReason,
+	}).Debug("Page expanded")
+
+	expansion := map[string]interface{}{
+		"mode":        mode,
+		"iterations":  iterations,
+		"stop_reason": stopReason,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if scroll.ItemSelector != "" {
+		expansion["items"] = items
+	}
+	return expansion, nil
+}
+
+// countItems returns how many elements match selector, or 0 without one
+func countItems(ctx context.Context, selector string) (int, error) {
+	if selector == "" {
+		return 0, nil
+	}
+	var count int
+	script := fmt.Sprintf(`document.querySelectorAll(%s).length`, jsString(selector))
+	if err := chromedp.Run(ctx, chromedp.Evaluate(script, &count)); err != nil {
+		return 0, fmt.Errorf("failed to count items: %w", err)
+	}
+	return count, nil
+}
+
+// waitForItems waits until more than previous items match selector or until
+// the deadline, returning the last count. Without a selector it waits until
+// the deadline.
+func waitForItems(ctx context.Context, selector string, previous int, deadline time.Time) (int, error) {
+	for {
+		count, err := countItems(ctx, selector)
+		if err != nil || (selector != "" && count > previous) {
+			return count, err
+		}
+		if !time.Now().Before(deadline) {
+			return count, nil
+		}
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case <-time.After(scrollPollInterval):
+		}
+	}
+}
+
+// loadMoreScript clicks the first visible, enabled element matching selector
+// and evaluates to whether there was one
+func loadMoreScript(selector string) string {
+	return fmt.Sprintf(`(() => {
+	const el = Array.from(document.querySelectorAll(%s)).find(e => e.offsetParent !== null && !e.disabled);
+	if (!el) { return false; }
+	el.scrollIntoView({block: "center"});
+	el.click();
+	return true;
+})()`, jsString(selector))
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
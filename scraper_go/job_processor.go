@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -18,6 +19,7 @@ type JobProcessor struct {
 	workerPool    chan struct{}
 	scraperEngine *ScraperEngine
 	s3Uploader    *S3Uploader
+	publisher     *TaskPublisher
 	reporter      *Reporter
 	logger        *logrus.Logger
 	wg            sync.WaitGroup
@@ -49,6 +51,13 @@ func NewJobProcessor(cfg *config.Config, jobChannel chan *models.TaskMessage) (*
 		return nil, fmt.Errorf("failed to create S3 uploader: %w", err)
 	}
 
+	// Create publisher for child tasks
+	publisher, err := NewTaskPublisher(cfg)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create task publisher: %w", err)
+	}
+
 	// Create reporter
 	reporter, err := NewReporter(cfg)
 	if err != nil {
@@ -65,6 +74,7 @@ func NewJobProcessor(cfg *config.Config, jobChannel chan *models.TaskMessage) (*
 		workerPool:    workerPool,
 		scraperEngine: scraperEngine,
 		s3Uploader:    s3Uploader,
+		publisher:     publisher,
 		reporter:      reporter,
 		logger:        logger,
 		ctx:           ctx,
@@ -93,6 +103,7 @@ func (jp *JobProcessor) Stop() {
 	jp.logger.Info("Stopping job processor")
 	jp.cancel()
 	jp.wg.Wait()
+	jp.scraperEngine.Close()
 }
 
 // worker is a single worker goroutine that processes jobs
@@ -125,6 +136,7 @@ func (jp *JobProcessor) worker(workerID int) {
 // processJob processes a single scraping job
 func (jp *JobProcessor) processJob(workerID int, job *models.TaskMessage) {
 	startTime := time.Now()
+	defer job.Processed()
 	
 	jp.logger.WithFields(logrus.Fields{
 		"worker_id": workerID,
@@ -151,8 +163,53 @@ func (jp *JobProcessor) processJob(workerID int, job *models.TaskMessage) {
 		jp.logger.WithError(err).WithField("task_id", job.TaskID).Warn("Failed to send initial status update")
 	}
 
-	// Process the scraping job
-	data, err := jp.scraperEngine.Scrape(job)
+	// Process the job according to its type
+	switch job.Type {
+	case models.TaskTypeCrawl:
+		statusUpdate = jp.runCrawl(workerID, job, result, startTime)
+	case models.TaskTypeSitemap:
+		statusUpdate = jp.runSitemap(workerID, job, result, startTime)
+	default:
+		statusUpdate = jp.runScrape(workerID, job, result, startTime)
+	}
+
+	// Send final status update
+	if err := jp.reporter.ReportStatus(statusUpdate); err != nil {
+		jp.logger.WithError(err).WithField("task_id", job.TaskID).Warn("Failed to send final status update")
+	}
+
+	jp.logger.WithFields(logrus.Fields{
+		"worker_id": workerID,
+		"task_id":   job.TaskID,
+		"status":    result.Status,
+		"duration":  result.Duration,
+		"cost":      result.Cost,
+	}).Info("Job completed")
+}
+
+// runScrape scrapes a single URL, uploads the result and returns the final
+// status update
+func (jp *JobProcessor) runScrape(workerID int, job *models.TaskMessage, result *models.ScrapingResult, startTime time.Time) *models.StatusUpdate {
+	var output *ScrapeOutput
+	var err error
+	if job.Type == models.TaskTypeReextract {
+		output, err = jp.reextract(job, result)
+	} else {
+		output, err = jp.scraperEngine.ScrapeContext(jp.ctx, job)
+	}
+	pages := 1
+	if output != nil {
+		result.Metadata = output.Metadata
+		if fetched, ok := output.Metadata["pages"].(int); ok {
+			pages = fetched
+		}
+	}
+
+	// Upload screenshots, PDFs and raw pages, also of failed extractions
+	var storeErr error
+	if output != nil {
+		storeErr = jp.storeFiles(job, output)
+	}
 	if err != nil {
 		jp.logger.WithError(err).WithFields(logrus.Fields{
 			"worker_id": workerID,
@@ -160,79 +217,229 @@ func (jp *JobProcessor) processJob(workerID int, job *models.TaskMessage) {
 			"url":       job.URL,
 		}).Error("Scraping failed")
 
-		result.Status = models.TaskStatusFailed
-		result.Error = err.Error()
-		result.Duration = time.Since(startTime).Milliseconds()
-		result.Cost = jp.calculateCost(job, false)
-
-		// Report failure
-		statusUpdate = &models.StatusUpdate{
-			TaskID:    job.TaskID,
-			Status:    models.TaskStatusFailed,
-			Error:     err.Error(),
-			Cost:      result.Cost,
-			Duration:  result.Duration,
-			Timestamp: time.Now(),
-		}
+		return jp.failJob(job, result, err, pages, startTime)
+	}
+
+	// Scraping successful
+	result.Data = output.Data
+	result.Status = models.TaskStatusCompleted
+	result.Duration = time.Since(startTime).Milliseconds()
+	result.Cost = jp.calculateCost(job, result.Metadata, pages, true)
+	if storeErr != nil {
+		recordWarning(result, storeErr)
+	}
+
+	// Upload to S3 with specified format
+	outputFormat := job.Options.OutputFormat
+	if outputFormat == "" {
+		outputFormat = jp.config.DefaultOutputFormat
+	}
+	
+	s3Location, err := jp.s3Uploader.UploadResult(result, outputFormat)
+	if err != nil {
+		jp.logger.WithError(err).WithField("task_id", job.TaskID).Error("Failed to upload result to S3")
+		recordError(result, err)
+		result.Cost = jp.calculateCost(job, result.Metadata, pages, false)
 	} else {
-		// Scraping successful
-		result.Data = data
-		result.Status = models.TaskStatusCompleted
-		result.Duration = time.Since(startTime).Milliseconds()
-		result.Cost = jp.calculateCost(job, true)
+		result.S3Location = s3Location
+	}
+
+	// Report success
+	return newStatusUpdate(result)
+}
+
+// storeFiles uploads the artifacts and raw pages of a scrape output and
+// records their locations in its metadata. Every upload is attempted; the
+// errors are returned joined.
+func (jp *JobProcessor) storeFiles(job *models.TaskMessage, output *ScrapeOutput) error {
+	if output.Metadata == nil {
+		output.Metadata = make(map[string]interface{})
+	}
 
-		// Upload to S3 with specified format
-		outputFormat := job.Options.OutputFormat
-		if outputFormat == "" {
-			outputFormat = jp.config.DefaultOutputFormat
+	var storeErr error
+	if len(output.Artifacts) > 0 {
+		artifacts, err := jp.uploadArtifacts(job.TaskID, output.Artifacts)
+		if len(artifacts) > 0 {
+			output.Metadata["artifacts"] = artifacts
+		}
+		if err != nil {
+			jp.logger.WithError(err).WithField("task_id", job.TaskID).Error("Failed to upload artifacts to S3")
+			storeErr = err
 		}
-		
-		s3Location, err := jp.s3Uploader.UploadResult(result, outputFormat)
+	}
+	if len(output.Pages) > 0 {
+		location, err := jp.archivePages(job, output.Pages)
 		if err != nil {
-			jp.logger.WithError(err).WithField("task_id", job.TaskID).Error("Failed to upload result to S3")
-			result.Error = fmt.Sprintf("Failed to upload to S3: %v", err)
-			result.Status = models.TaskStatusFailed
+			jp.logger.WithError(err).WithField("task_id", job.TaskID).Error("Failed to archive pages to S3")
+			storeErr = errors.Join(storeErr, err)
 		} else {
-			result.S3Location = s3Location
+			output.Metadata["page_archive"] = location
 		}
+	}
+	return storeErr
+}
+
+// reextract loads the page archive of a reextract task and extracts the
+// task schema from it, reporting the archived URL when the task has none
+func (jp *JobProcessor) reextract(job *models.TaskMessage, result *models.ScrapingResult) (*ScrapeOutput, error) {
+	if err := validateOutputFormat(job); err != nil {
+		return nil, err
+	}
+	archive, err := jp.loadPageArchive(job)
+	if err != nil {
+		return nil, err
+	}
+	if result.URL == "" {
+		result.URL = archive.URL
+	}
+	return jp.scraperEngine.Reextract(job, archive)
+}
 
-		// Report success
-		statusUpdate = &models.StatusUpdate{
-			TaskID:     job.TaskID,
-			Status:     result.Status,
-			Cost:       result.Cost,
-			Duration:   result.Duration,
-			S3Location: result.S3Location,
-			Timestamp:  time.Now(),
+// runCrawl crawls from the task URL, uploads the per-page records as an
+// NDJSON dataset and returns the final status update
+func (jp *JobProcessor) runCrawl(workerID int, job *models.TaskMessage, result *models.ScrapingResult, startTime time.Time) *models.StatusUpdate {
+	output, err := jp.scraperEngine.Crawl(job)
+	if err != nil {
+		jp.logger.WithError(err).WithFields(logrus.Fields{
+			"worker_id": workerID,
+			"task_id":   job.TaskID,
+			"url":       job.URL,
+		}).Error("Crawl failed")
+
+		return jp.failJob(job, result, err, 1, startTime)
+	}
+
+	return jp.completeDataset(job, result, output, len(output.Records), startTime)
+}
+
+// runSitemap expands the task's sitemap and either scrapes every URL into an
+// NDJSON dataset or publishes one child task per URL to the queue
+func (jp *JobProcessor) runSitemap(workerID int, job *models.TaskMessage, result *models.ScrapingResult, startTime time.Time) *models.StatusUpdate {
+	expansion, err := jp.scraperEngine.ExpandSitemap(jp.ctx, job)
+	if err != nil {
+		jp.logger.WithError(err).WithFields(logrus.Fields{
+			"worker_id": workerID,
+			"task_id":   job.TaskID,
+			"url":       job.URL,
+		}).Error("Sitemap expansion failed")
+
+		return jp.failJob(job, result, err, 1, startTime)
+	}
+
+	if job.Options.Sitemap != nil && job.Options.Sitemap.Action == "enqueue" {
+		tasks := make([]*models.TaskMessage, len(expansion.Entries))
+		for i, entry := range expansion.Entries {
+			tasks[i] = job.ChildTask(i, entry.Loc)
+		}
+
+		published, err := jp.publisher.PublishTasks(tasks)
+		result.Metadata = map[string]interface{}{
+			"sitemaps": expansion.Sitemaps,
+			"urls":     len(expansion.Entries),
+			"enqueued": published,
 		}
+		if err != nil {
+			return jp.failJob(job, result, err, expansion.Sitemaps, startTime)
+		}
+
+		result.Status = models.TaskStatusCompleted
+		result.Duration = time.Since(startTime).Milliseconds()
+		result.Cost = jp.calculateCost(job, result.Metadata, expansion.Sitemaps, true)
+
+		return newStatusUpdate(result)
 	}
 
-	// Send final status update
-	if err := jp.reporter.ReportStatus(statusUpdate); err != nil {
-		jp.logger.WithError(err).WithField("task_id", job.TaskID).Warn("Failed to send final status update")
+	output := jp.scraperEngine.ScrapeEntries(jp.ctx, job, expansion.Entries, jp.storeFiles)
+	output.Metadata["sitemaps"] = expansion.Sitemaps
+	output.Metadata["urls"] = len(expansion.Entries)
+
+	return jp.completeDataset(job, result, output, expansion.Sitemaps+len(output.Records), startTime)
+}
+
+// failJob marks a job as failed and returns the failure status update
+func (jp *JobProcessor) failJob(job *models.TaskMessage, result *models.ScrapingResult, err error, pages int, startTime time.Time) *models.StatusUpdate {
+	recordError(result, err)
+	result.Duration = time.Since(startTime).Milliseconds()
+	result.Cost = jp.calculateCost(job, result.Metadata, pages, false)
+
+	return newStatusUpdate(result)
+}
+
+// completeDataset uploads per-page records as an NDJSON dataset and returns
+// the final status update
+func (jp *JobProcessor) completeDataset(job *models.TaskMessage, result *models.ScrapingResult, output *CrawlOutput, pages int, startTime time.Time) *models.StatusUpdate {
+	result.Metadata = output.Metadata
+	result.Status = models.TaskStatusCompleted
+	result.Duration = time.Since(startTime).Milliseconds()
+	result.Cost = jp.calculateCost(job, output.Metadata, pages, true)
+
+	s3Location, err := jp.s3Uploader.UploadDataset(job.TaskID, output.Records)
+	if err != nil {
+		jp.logger.WithError(err).WithField("task_id", job.TaskID).Error("Failed to upload dataset to S3")
+		recordError(result, err)
+		result.Cost = jp.calculateCost(job, result.Metadata, pages, false)
+	} else {
+		result.S3Location = s3Location
+		result.Metadata["dataset_location"] = s3Location
 	}
 
-	jp.logger.WithFields(logrus.Fields{
-		"worker_id": workerID,
-		"task_id":   job.TaskID,
-		"status":    result.Status,
-		"duration":  result.Duration,
-		"cost":      result.Cost,
-	}).Info("Job completed")
+	return newStatusUpdate(result)
 }
 
-// calculateCost calculates the cost of a scraping job
-func (jp *JobProcessor) calculateCost(job *models.TaskMessage, success bool) float64 {
-	baseCost := 0.01 // Base cost per job
+// newStatusUpdate creates the final status update reporting a result
+func newStatusUpdate(result *models.ScrapingResult) *models.StatusUpdate {
+	return &models.StatusUpdate{
+		TaskID:      result.TaskID,
+		Status:      result.Status,
+		Error:       result.Error,
+		ErrorCode:   result.ErrorCode,
+		Retryable:   result.Retryable,
+		HTTPStatus:  result.HTTPStatus,
+		Warning:     result.Warning,
+		WarningCode: result.WarningCode,
+		Cost:        result.Cost,
+		Duration:    result.Duration,
+		S3Location:  result.S3Location,
+		Artifacts:   resultArtifacts(result),
+		Timestamp:   time.Now(),
+	}
+}
 
-	// Add cost for JavaScript rendering
-	if job.Options.EnableJS {
-		baseCost += 0.02
+// resultArtifacts returns the uploaded artifacts listed in a result's metadata
+func resultArtifacts(result *models.ScrapingResult) []models.Artifact {
+	artifacts, _ := result.Metadata["artifacts"].([]models.Artifact)
+	return artifacts
+}
+
+// calculateCost calculates the cost of a scraping job from the pages it
+// fetched and the retries and engine recorded in its metadata
+func (jp *JobProcessor) calculateCost(job *models.TaskMessage, metadata map[string]interface{}, pages int, success bool) float64 {
+	// Re-extracting archived pages fetches nothing
+	if job.Type == models.TaskTypeReextract {
+		baseCost := 0.001 * float64(max(pages, 1))
+		if !success {
+			baseCost = baseCost * 0.5
+		}
+		return baseCost
 	}
 
-	// Add cost for retries
-	if job.Options.MaxRetries > 0 {
-		baseCost += float64(job.Options.MaxRetries) * 0.005
+	pageCost := 0.01 // Base cost per page
+
+	// Add cost for JavaScript rendering, including auto engine tasks that
+	// fell back to Chrome and tasks scripting browser actions
+	if usesChrome(job) || metadata["engine"] == engineChrome {
+		pageCost += 0.02
+	}
+
+	// Every fetched page is charged, paginated tasks fetch several
+	if pages < 1 {
+		pages = 1
+	}
+	baseCost := pageCost * float64(pages)
+
+	// Add cost for the retries actually made
+	if retries := metadataInt(metadata, "retries"); retries > 0 {
+		baseCost += float64(retries) * 0.005
 	}
 
 	// Add cost for proxy usage
@@ -248,6 +455,12 @@ func (jp *JobProcessor) calculateCost(job *models.TaskMessage, success bool) flo
 	return baseCost
 }
 
+// metadataInt returns an integer metadata entry, or 0 when it is missing
+func metadataInt(metadata map[string]interface{}, key string) int {
+	value, _ := metadata[key].(int)
+	return value
+}
+
 // getLogLevel converts string log level to logrus level
 func getLogLevel(level string) logrus.Level {
 	switch level {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractDataFromJSONBody decodes a JSON response body and extracts the
+// schema from it. Bodies rendered by a browser wrap the JSON in HTML, so the
+// page text is used in that case.
+func (se *ScraperEngine) extractDataFromJSONBody(body []byte, schema map[string]interface{}) (map[string]interface{}, *extractionReport, error) {
+	root, err := decodeJSONBody(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return se.extractDataFromJSON(root, schema)
+}
+
+// decodeJSONBody decodes a JSON response body, unwrapping the HTML document
+// a browser renders around it
+func decodeJSONBody(body []byte) (interface{}, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '<' {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTML wrapped JSON: %w", err)
+		}
+		trimmed = []byte(strings.TrimSpace(doc.Find("body").Text()))
+	}
+
+	var root interface{}
+	if err := json.Unmarshal(trimmed, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return root, nil
+}
+
+// extractDataFromJSON extracts data from a decoded JSON document. Fields use
+// a "path" (JSONPath like "$.data.items[0].name" or gjson style
+// "data.items.0.name") instead of a selector and support the value, text,
+// list, object and items types.
+func (se *ScraperEngine) extractDataFromJSON(root interface{}, schema map[string]interface{}) (map[string]interface{}, *extractionReport, error) {
+	report := newExtractionReport()
+	result := se.extractJSONFields(root, schema, "", report)
+	return result, report, report.err()
+}
+
+// extractJSONFields evaluates a schema relative to a JSON value
+func (se *ScraperEngine) extractJSONFields(scope interface{}, schema map[string]interface{}, path string, report *extractionReport) map[string]interface{} {
+	return se.extractSchema(schema, path, report, func(fieldName string, config map[string]interface{}, fieldPath string) (interface{}, error) {
+		return se.extractJSONField(scope, fieldName, config, fieldPath, report)
+	})
+}
+
+// extractJSONField extracts a single field from a JSON value. The default
+// "value" type keeps the JSON type of the match; "text" converts it to a string.
+func (se *ScraperEngine) extractJSONField(scope interface{}, fieldName string, config map[string]interface{}, path string, report *extractionReport) (interface{}, error) {
+	jsonPath, ok := config["path"].(string)
+	if !ok {
+		jsonPath, ok = config["selector"].(string)
+	}
+	if !ok {
+		return nil, fmt.Errorf("path is required for field %s", fieldName)
+	}
+
+	fieldType, ok := config["type"].(string)
+	if !ok {
+		fieldType = "value"
+	}
+
+	matches, multiple, err := evalJSONPath(scope, jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	switch fieldType {
+	case "value", "text":
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w: no value found at path: %s", errSelectorMiss, jsonPath)
+		}
+		result = matches[0]
+		if fieldType == "text" {
+			result = jsonText(matches[0])
+		}
+	case "list":
+		result = jsonList(matches, multiple)
+	case "object":
+		fields, ok := config["fields"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("fields is required for object extraction")
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w: no value found at path: %s", errSelectorMiss, jsonPath)
+		}
+		result = se.extractJSONFields(matches[0], fields, path, report)
+	case "items":
+		fields, ok := config["fields"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("fields is required for items extraction")
+		}
+		elements := jsonList(matches, multiple)
+		items := make([]map[string]interface{}, 0, len(elements))
+		for _, element := range elements {
+			items = append(items, se.extractJSONFields(element, fields, path+"[]", report))
+		}
+		result = items
+	default:
+		return nil, fmt.Errorf("unsupported field type for JSON responses: %s", fieldType)
+	}
+
+	return se.postProcessField(result, config)
+}
+
+// jsonList returns the elements of a list field: all matches of a path that
+// can match several values, or the elements of a single matched array
+func jsonList(matches []interface{}, multiple bool) []interface{} {
+	if !multiple && len(matches) == 1 {
+		if array, ok := matches[0].([]interface{}); ok {
+			return array
+		}
+	}
+	if matches == nil {
+		return []interface{}{}
+	}
+	return matches
+}
+
+// jsonText converts a JSON value to its text form
+func jsonText(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	default:
+		encoded, err := json.Marshal(typed)
+		if err != nil {
+			return fmt.Sprint(typed)
+		}
+		return string(encoded)
+	}
+}
+
+// evalJSONPath evaluates a path against a JSON value and returns all
+// matches. Supported syntax: an optional "$" root, dotted keys, bracket
+// indices ("[0]", negative from the end), quoted keys ("['a.b']") and
+// wildcards ("*", "[*]" or gjson's "#"). Keys applied to an array are
+// applied to each element. The boolean reports whether the path can match
+// more than one value.
+func evalJSONPath(root interface{}, path string) ([]interface{}, bool, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	current := []interface{}{root}
+	multiple := false
+
+	for _, segment := range segments {
+		var next []interface{}
+		for _, value := range current {
+			switch typed := value.(type) {
+			case map[string]interface{}:
+				if segment == "*" || segment == "#" {
+					keys := make([]string, 0, len(typed))
+					for key := range typed {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, typed[key])
+					}
+					multiple = true
+				} else if child, ok := typed[segment]; ok {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if segment == "*" || segment == "#" {
+					next = append(next, typed...)
+					multiple = true
+				} else if index, err := strconv.Atoi(segment); err == nil {
+					if index < 0 {
+						index += len(typed)
+					}
+					if index >= 0 && index < len(typed) {
+						next = append(next, typed[index])
+					}
+				} else {
+					for _, element := range typed {
+						if object, ok := element.(map[string]interface{}); ok {
+							if child, ok := object[segment]; ok {
+								next = append(next, child)
+							}
+						}
+					}
+					multiple = true
+				}
+			}
+		}
+		current = next
+	}
+
+	return current, multiple, nil
+}
+
+// parseJSONPath splits a JSONPath or gjson style path into segments
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	var segments []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			segments = append(segments, current.String())
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %s: unclosed bracket", path)
+			}
+			segment := strings.TrimSpace(path[i+1 : i+end])
+			segment = strings.Trim(segment, `'"`)
+			segments = append(segments, segment)
+			i += end
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+	flush()
+
+	return segments, nil
+}
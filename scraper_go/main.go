@@ -52,6 +52,8 @@ func main() {
 
 	// Create health checker
 	healthChecker := NewHealthChecker(cfg, reporter)
+	healthChecker.RegisterMetrics(processor.scraperEngine.limiter)
+	healthChecker.RegisterMetrics(processor.scraperEngine.browsers)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -17,27 +17,71 @@ const (
 	TaskStatusFailed     TaskStatus = "failed"
 )
 
+// TaskType selects how a task is processed
+type TaskType string
+
+const (
+	TaskTypeScrape    TaskType = "scrape"    // scrape a single URL (default)
+	TaskTypeCrawl     TaskType = "crawl"     // follow links from URL and scrape every matched page
+	TaskTypeSitemap   TaskType = "sitemap"   // expand the sitemap at URL and scrape or enqueue its URLs
+	TaskTypeReextract TaskType = "reextract" // extract from the page archive at Archive instead of fetching URL
+)
+
+// ErrorCode classifies why a task failed
+type ErrorCode string
+
+const (
+	ErrorCodeNetwork          ErrorCode = "network"           // connection failed or dropped
+	ErrorCodeTimeout          ErrorCode = "timeout"           // request or browser timed out
+	ErrorCodeHTTPStatus       ErrorCode = "http_status"       // the page responded with an error status
+	ErrorCodeBlocked          ErrorCode = "blocked"           // the site blocked the request
+	ErrorCodeCaptchaFailed    ErrorCode = "captcha_failed"    // a CAPTCHA could not be solved
+	ErrorCodeRobotsDisallowed ErrorCode = "robots_disallowed" // robots.txt disallows the URL
+	ErrorCodeSelectorMiss     ErrorCode = "selector_miss"     // required schema fields were not found
+	ErrorCodeUploadFailed     ErrorCode = "upload_failed"     // the result could not be stored
+	ErrorCodeInvalidTask      ErrorCode = "invalid_task"      // the task message is invalid
+	ErrorCodeInternal         ErrorCode = "internal"          // any other failure
+)
+
 // TaskMessage represents a message from SQS containing task details
 type TaskMessage struct {
-	TaskID      string                 `json:"task_id"`
-	URL         string                 `json:"url"`
-	Schema      map[string]interface{} `json:"schema"`
-	Options     ScrapingOptions        `json:"options"`
-	CallbackURL string                 `json:"callback_url,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
+	TaskID       string                 `json:"task_id"`
+	ParentTaskID string                 `json:"parent_task_id,omitempty"`
+	Type         TaskType               `json:"type,omitempty"`
+	URL          string                 `json:"url"`
+	Archive      string                 `json:"archive,omitempty"` // reextract: s3://bucket/key, key in the results bucket or local file
+	Schema       map[string]interface{} `json:"schema"`
+	Options      ScrapingOptions        `json:"options"`
+	CallbackURL  string                 `json:"callback_url,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+
+	// OnProcessed is set by the queue consumer for long-running tasks, whose
+	// message is only deleted once the task has been processed
+	OnProcessed func() `json:"-"`
 }
 
 // ScrapingOptions contains configuration options for scraping
 type ScrapingOptions struct {
-	UserAgent      string            `json:"user_agent,omitempty"`
-	Timeout        int               `json:"timeout,omitempty"` // in seconds
-	EnableJS       bool              `json:"enable_js,omitempty"`
-	WaitForElement string            `json:"wait_for_element,omitempty"`
-	Headers        map[string]string `json:"headers,omitempty"`
-	ProxyURL       string            `json:"proxy_url,omitempty"`
-	MaxRetries     int               `json:"max_retries,omitempty"`
-	RetryDelay     int               `json:"retry_delay,omitempty"` // in seconds
-	RespectRobots  bool              `json:"respect_robots,omitempty"`
+	UserAgent       string                 `json:"user_agent,omitempty"`
+	Timeout         int                    `json:"timeout,omitempty"` // in seconds
+	EnableJS        bool                   `json:"enable_js,omitempty"`
+	Engine          string                 `json:"engine,omitempty"` // colly, chrome or auto (probe with Colly, fall back to Chrome)
+	WaitForElement  string                 `json:"wait_for_element,omitempty"`
+	Headers         map[string]string      `json:"headers,omitempty"`
+	ProxyURL        string                 `json:"proxy_url,omitempty"`
+	MaxRetries      int                    `json:"max_retries,omitempty"`
+	RetryDelay      int                    `json:"retry_delay,omitempty"`      // in seconds
+	RotateProxy     bool                   `json:"rotate_proxy,omitempty"`     // retry with the next PROXY_LIST proxy
+	BlockEscalation []string               `json:"block_escalation,omitempty"` // steps tried when blocked: rotate_proxy, stealth_js
+	RespectRobots   bool                   `json:"respect_robots,omitempty"`
+	ResponseFormat  string                 `json:"response_format,omitempty"` // auto (default), html, json, xml, feed
+	Pagination      *PaginationOptions     `json:"pagination,omitempty"`
+	Crawl           *CrawlOptions          `json:"crawl,omitempty"`
+	Sitemap         *SitemapOptions        `json:"sitemap,omitempty"`
+	Actions         []BrowserAction        `json:"actions,omitempty"`         // scripted browser steps run before extraction (Chrome)
+	InfiniteScroll  *InfiniteScrollOptions `json:"infinite_scroll,omitempty"` // load more content before extraction (Chrome)
+	Artifacts       []ArtifactRequest      `json:"artifacts,omitempty"`       // screenshots and PDFs captured before extraction (Chrome)
+	ArchivePage     bool                   `json:"archive_page,omitempty"`    // store the raw pages, final URLs and headers for re-extraction
 	
 	// Output format options
 	OutputFormat   string            `json:"output_format,omitempty"` // json, html, xml, md, csv
@@ -60,6 +104,86 @@ type ScrapingOptions struct {
 	CanvasFingerprint  bool              `json:"canvas_fingerprint,omitempty"`
 }
 
+// PaginationOptions configures following several pages within one task.
+// The task URL is always the first page.
+type PaginationOptions struct {
+	Mode          string `json:"mode,omitempty"`            // next, page, offset, cursor (inferred when empty)
+	NextSelector  string `json:"next_selector,omitempty"`   // CSS selector of the next page link, or JSON path of the next URL
+	URLTemplate   string `json:"url_template,omitempty"`    // URL with a {page}, {offset} or {cursor} placeholder
+	Param         string `json:"param,omitempty"`           // query parameter set on the task URL instead of a template
+	Start         int    `json:"start,omitempty"`           // page number or offset of the first page (default 1 for page, 0 for offset)
+	Step          int    `json:"step,omitempty"`            // increment per page (default 1 for page, first page item count for offset)
+	CursorPath    string `json:"cursor_path,omitempty"`     // CSS selector or JSON path of the next cursor
+	MaxPages      int    `json:"max_pages,omitempty"`       // including the first page (default 10)
+	StopIfMissing string `json:"stop_if_missing,omitempty"` // stop once a page has no match for this selector or path
+}
+
+// BrowserAction is a scripted browser step run in order after the page has
+// loaded and before extraction. Tasks with actions are fetched with Chrome.
+type BrowserAction struct {
+	Type     string `json:"type"`               // navigate, click, type, select, hover, press, scroll, wait_for_selector, wait_timeout, wait_network_idle, evaluate, snapshot
+	Selector string `json:"selector,omitempty"` // CSS selector of the target element
+	URL      string `json:"url,omitempty"`      // navigate: URL to open
+	Value    string `json:"value,omitempty"`    // type: text, select: option value, press: key name such as "Enter"
+	Script   string `json:"script,omitempty"`   // evaluate: JavaScript expression, its result is recorded
+	X        int    `json:"x,omitempty"`        // scroll: pixels to scroll by (to the bottom when x and y are 0)
+	Y        int    `json:"y,omitempty"`
+	Duration int    `json:"duration,omitempty"` // wait_timeout: milliseconds to wait
+	Name     string `json:"name,omitempty"`     // snapshot: key of the extracted snapshot
+	Timeout  int    `json:"timeout,omitempty"`  // step timeout in seconds (default 10)
+}
+
+// InfiniteScrollOptions configures loading more content before extraction,
+// by scrolling to the bottom or by clicking a "load more" element until no
+// new items appear or a bound is reached
+type InfiniteScrollOptions struct {
+	ItemSelector     string `json:"item_selector,omitempty"`      // CSS selector of the items, counted to detect new content
+	LoadMoreSelector string `json:"load_more_selector,omitempty"` // click this element instead of scrolling
+	MaxIterations    int    `json:"max_iterations,omitempty"`     // scrolls or clicks (default 20)
+	MaxItems         int    `json:"max_items,omitempty"`          // stop once this many items are loaded
+	TimeBudget       int    `json:"time_budget,omitempty"`        // in seconds (default 30)
+	Wait             int    `json:"wait,omitempty"`               // milliseconds to wait for new items per iteration (default 2000)
+}
+
+// ArtifactRequest asks for a screenshot or PDF of the rendered page,
+// captured after actions and infinite scroll, right before extraction
+type ArtifactRequest struct {
+	Type     string `json:"type"`               // full_page_screenshot, viewport_screenshot, element_screenshot or pdf
+	Selector string `json:"selector,omitempty"` // element_screenshot: CSS selector of the element
+	Name     string `json:"name,omitempty"`     // file name without extension (default: the type, numbered when repeated)
+}
+
+// Artifact is an uploaded screenshot or PDF of a task
+type Artifact struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"` // in bytes
+	S3Location  string `json:"s3_location"`
+}
+
+// CrawlOptions configures crawl tasks. Links are followed when they are on
+// an allowed domain, match one of the allow patterns (when any are given)
+// and none of the deny patterns.
+type CrawlOptions struct {
+	MaxDepth       int      `json:"max_depth,omitempty"`       // link hops from the start URL (default 2)
+	MaxPages       int      `json:"max_pages,omitempty"`       // pages fetched including the start URL (default 100)
+	AllowedDomains []string `json:"allowed_domains,omitempty"` // defaults to the start URL's host
+	Allow          []string `json:"allow,omitempty"`           // regular expressions a followed URL must match
+	Deny           []string `json:"deny,omitempty"`            // regular expressions excluding URLs
+}
+
+// SitemapOptions configures sitemap tasks. The task URL is a sitemap, a
+// sitemap index or a site root / robots.txt whose Sitemap entries are used.
+type SitemapOptions struct {
+	Action        string   `json:"action,omitempty"`         // scrape (default) or enqueue
+	Include       []string `json:"include,omitempty"`        // regular expressions a URL must match
+	Exclude       []string `json:"exclude,omitempty"`        // regular expressions excluding URLs
+	LastmodAfter  string   `json:"lastmod_after,omitempty"`  // keep URLs modified at or after this date
+	LastmodBefore string   `json:"lastmod_before,omitempty"` // keep URLs modified before this date
+	MaxURLs       int      `json:"max_urls,omitempty"`       // default 1000
+}
+
 // ScrapingResult represents the result of a scraping operation
 type ScrapingResult struct {
 	TaskID      string                 `json:"task_id"`
@@ -67,6 +191,11 @@ type ScrapingResult struct {
 	Data        map[string]interface{} `json:"data"`
 	Status      TaskStatus             `json:"status"`
 	Error       string                 `json:"error,omitempty"`
+	ErrorCode   ErrorCode              `json:"error_code,omitempty"`
+	Retryable   bool                   `json:"retryable,omitempty"`
+	HTTPStatus  int                    `json:"http_status,omitempty"`
+	Warning     string                 `json:"warning,omitempty"`
+	WarningCode ErrorCode              `json:"warning_code,omitempty"`
 	Cost        float64                `json:"cost"`
 	Duration    int64                  `json:"duration"` // in milliseconds
 	Timestamp   time.Time              `json:"timestamp"`
@@ -76,13 +205,19 @@ type ScrapingResult struct {
 
 // StatusUpdate represents a status update to be sent to the Node.js API
 type StatusUpdate struct {
-	TaskID     string     `json:"task_id"`
-	Status     TaskStatus `json:"status"`
-	Error      string     `json:"error,omitempty"`
-	Cost       float64    `json:"cost,omitempty"`
-	Duration   int64      `json:"duration,omitempty"`
-	S3Location string     `json:"s3_location,omitempty"`
-	Timestamp  time.Time  `json:"timestamp"`
+	TaskID      string     `json:"task_id"`
+	Status      TaskStatus `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ErrorCode   ErrorCode  `json:"error_code,omitempty"`
+	Retryable   bool       `json:"retryable,omitempty"`
+	HTTPStatus  int        `json:"http_status,omitempty"`
+	Warning     string     `json:"warning,omitempty"`
+	WarningCode ErrorCode  `json:"warning_code,omitempty"`
+	Cost        float64    `json:"cost,omitempty"`
+	Duration    int64      `json:"duration,omitempty"`
+	S3Location  string     `json:"s3_location,omitempty"`
+	Artifacts   []Artifact `json:"artifacts,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
 }
 
 // ProxyInfo represents proxy configuration
@@ -102,6 +237,34 @@ func ParseTaskMessage(jsonData string) (*TaskMessage, error) {
 	return &task, nil
 }
 
+// ChildTask returns a single-URL scrape task derived from a sitemap or crawl
+// task, sharing its schema, options and callback
+func (tm *TaskMessage) ChildTask(index int, url string) *TaskMessage {
+	child := *tm
+	child.TaskID = fmt.Sprintf("%s-%d", tm.TaskID, index+1)
+	child.ParentTaskID = tm.TaskID
+	child.Type = TaskTypeScrape
+	child.URL = url
+	child.Options.Crawl = nil
+	child.Options.Sitemap = nil
+	child.CreatedAt = time.Now()
+	child.OnProcessed = nil
+	return &child
+}
+
+// LongRunning reports whether the task fetches many pages from one message:
+// crawls and sitemaps
+func (tm *TaskMessage) LongRunning() bool {
+	return tm.Type == TaskTypeCrawl || tm.Type == TaskTypeSitemap
+}
+
+// Processed tells the queue consumer that the task has been processed
+func (tm *TaskMessage) Processed() {
+	if tm.OnProcessed != nil {
+		tm.OnProcessed()
+	}
+}
+
 // ToJSON converts a TaskMessage to JSON string
 func (tm *TaskMessage) ToJSON() (string, error) {
 	data, err := json.Marshal(tm)
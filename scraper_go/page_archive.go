@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+// pageArchive is the raw content of the pages a task fetched, stored
+// gzip-compressed as JSON so extraction can be re-run without refetching
+type pageArchive struct {
+	TaskID    string         `json:"task_id"`
+	URL       string         `json:"url"`
+	CreatedAt time.Time      `json:"created_at"`
+	Pages     []archivedPage `json:"pages"`
+}
+
+// archivedPage is one fetched page of a pageArchive. The body is the HTML
+// after rendering for Chrome tasks.
+type archivedPage struct {
+	URL         string      `json:"url"` // final URL after redirects
+	StatusCode  int         `json:"status_code,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+	Headers     http.Header `json:"headers,omitempty"`
+	Body        []byte      `json:"body"`
+}
+
+// encodePageArchive builds the gzip-compressed archive of a task's pages
+func encodePageArchive(task *models.TaskMessage, pages []*fetchedPage) ([]byte, error) {
+	archive := pageArchive{
+		TaskID:    task.TaskID,
+		URL:       task.URL,
+		CreatedAt: time.Now(),
+	}
+	for _, page := range pages {
+		archive.Pages = append(archive.Pages, archivedPage{
+			URL:         page.URL,
+			StatusCode:  page.StatusCode,
+			ContentType: page.ContentType,
+			Headers:     page.Headers,
+			Body:        page.Body,
+		})
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(archive); err != nil {
+		return nil, fmt.Errorf("failed to encode page archive: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress page archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// archivePages uploads the raw pages of a task and returns the archive's S3
+// location
+func (jp *JobProcessor) archivePages(task *models.TaskMessage, pages []*fetchedPage) (string, error) {
+	data, err := encodePageArchive(task, pages)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errUploadFailed, err)
+	}
+	return jp.s3Uploader.UploadPageArchive(task.TaskID, data)
+}
+
+// watchDocumentResponse records the status and headers of the main frame's
+// document responses in a Chrome tab. The returned function reports the
+// latest one.
+func watchDocumentResponse(ctx context.Context) func() (int, http.Header) {
+	var mu sync.Mutex
+	var response *network.Response
+
+	// The main frame of a page target has the target's ID
+	var mainFrame string
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		mainFrame = string(c.Target.TargetID)
+	}
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		received, ok := ev.(*network.EventResponseReceived)
+		if !ok || received.Type != network.ResourceTypeDocument || string(received.FrameID) != mainFrame {
+			return
+		}
+		mu.Lock()
+		response = received.Response
+		mu.Unlock()
+	})
+
+	return func() (int, http.Header) {
+		mu.Lock()
+		defer mu.Unlock()
+		if response == nil {
+			return 0, nil
+		}
+		headers := make(http.Header)
+		for name, value := range response.Headers {
+			// Chrome joins repeated headers with newlines
+			for _, line := range strings.Split(fmt.Sprint(value), "\n") {
+				headers.Add(name, line)
+			}
+		}
+		return int(response.Status), headers
+	}
+}
+
+// decodePageArchive decodes a page archive, gzip-compressed or not. Data
+// that is not an archive is taken as a single raw page of url.
+func decodePageArchive(data []byte, url string) (*pageArchive, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress page archive: %w", err)
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("failed to decompress page archive: %w", err)
+		}
+	}
+
+	var archive pageArchive
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &archive); err == nil && len(archive.Pages) > 0 {
+			return &archive, nil
+		}
+	}
+
+	return &pageArchive{
+		URL:   url,
+		Pages: []archivedPage{{URL: url, Body: data}},
+	}, nil
+}
+
+// loadPageArchive reads the archive of a reextract task from S3 or, when it
+// is a file:// URL or an absolute path, from ARCHIVE_LOCAL_DIR
+func (jp *JobProcessor) loadPageArchive(task *models.TaskMessage) (*pageArchive, error) {
+	var data []byte
+	var err error
+	if path, local := localArchivePath(task.Archive); local {
+		data, err = jp.readLocalArchive(path)
+	} else {
+		data, err = jp.s3Uploader.DownloadArchive(task.Archive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePageArchive(data, task.URL)
+}
+
+// localArchivePath returns the file path of an archive given as a file://
+// URL or an absolute path
+func localArchivePath(archive string) (string, bool) {
+	if path, ok := strings.CutPrefix(archive, "file://"); ok {
+		return path, true
+	}
+	return archive, filepath.IsAbs(archive)
+}
+
+// readLocalArchive reads an archive file, which must be inside
+// ARCHIVE_LOCAL_DIR once symlinks are resolved
+func (jp *JobProcessor) readLocalArchive(path string) ([]byte, error) {
+	if jp.config.ArchiveLocalDir == "" {
+		return nil, fmt.Errorf("%w: local archives are disabled, set ARCHIVE_LOCAL_DIR", errInvalidTask)
+	}
+	dir, err := filepath.Abs(jp.config.ArchiveLocalDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARCHIVE_LOCAL_DIR: %w", err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid archive path: %w", errInvalidTask, err)
+	}
+	if !insideDir(dir, path) {
+		return nil, fmt.Errorf("%w: archive %s is outside ARCHIVE_LOCAL_DIR", errInvalidTask, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if !insideDir(dir, resolved) {
+		return nil, fmt.Errorf("%w: archive %s links outside ARCHIVE_LOCAL_DIR", errInvalidTask, path)
+	}
+	path = resolved
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return data, nil
+}
+
+// insideDir reports whether path is dir or inside it
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Reextract runs the task schema on the pages of an archive instead of
+// fetching them. Items of later pages are merged into the first page's data
+// as with pagination; a later page that fails extraction is skipped.
+func (se *ScraperEngine) Reextract(task *models.TaskMessage, archive *pageArchive) (*ScrapeOutput, error) {
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"archive": task.Archive,
+		"pages":   len(archive.Pages),
+	}).Info("Re-extracting archived pages")
+
+	if len(archive.Pages) == 0 {
+		return nil, fmt.Errorf("%w: archive has no pages", errInvalidTask)
+	}
+
+	// Archived pages are not archived again
+	extractTask := *task
+	extractTask.Options.ArchivePage = false
+
+	var output *ScrapeOutput
+	seen := make(map[string]bool)
+	for i, archived := range archive.Pages {
+		page := &fetchedPage{
+			URL:         archived.URL,
+			StatusCode:  archived.StatusCode,
+			ContentType: archived.ContentType,
+			Headers:     archived.Headers,
+			Body:        archived.Body,
+		}
+		pageOutput, err := se.extractFromPage(&extractTask, page)
+
+		if i == 0 {
+			if pageOutput == nil {
+				return nil, err
+			}
+			output = pageOutput
+			output.Metadata["archive"] = task.Archive
+			if !archive.CreatedAt.IsZero() {
+				output.Metadata["archived_at"] = archive.CreatedAt
+			}
+			output.Metadata["pages"] = len(archive.Pages)
+			if err != nil {
+				return output, err
+			}
+			mergePageData(output.Data, nil, seen)
+			continue
+		}
+
+		if err != nil {
+			se.logger.WithError(err).WithFields(logrus.Fields{
+				"task_id": task.TaskID,
+				"url":     archived.URL,
+			}).Warn("Failed to extract archived page, skipping it")
+			continue
+		}
+		mergePageData(output.Data, pageOutput.Data, seen)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+// defaultMaxPages limits pagination when the task sets no max_pages
+const defaultMaxPages = 10
+
+// scrapePaginated scrapes the task URL and the pages following it, merging
+// list and items fields across pages. Pagination stops at max_pages, when no
+// next page can be found, when a page adds no new items or when the
+// stop_if_missing selector no longer matches. Failures on later pages end
+// pagination without failing the task. A first page already fetched by the
+// caller is used instead of fetching the task URL again.
+func (se *ScraperEngine) scrapePaginated(task *models.TaskMessage, page *fetchedPage) (*ScrapeOutput, error) {
+	pagination := task.Options.Pagination
+	mode := paginationMode(pagination)
+
+	maxPages := pagination.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	if page == nil {
+		var err error
+		if page, err = se.fetchPage(task, task.URL); err != nil {
+			return nil, err
+		}
+	}
+	output, err := se.extractFromPage(task, page)
+	if err != nil {
+		return output, err
+	}
+
+	seen := make(map[string]bool)
+	mergePageData(output.Data, nil, seen)
+
+	start, step := pagination.Start, pagination.Step
+	if mode == "offset" && step <= 0 {
+		step = largestList(output.Data)
+	}
+	if mode == "page" {
+		if start == 0 {
+			start = 1
+		}
+		if step <= 0 {
+			step = 1
+		}
+	}
+
+	visited := map[string]bool{task.URL: true, page.URL: true}
+	pages := 1
+	stopReason := "max_pages"
+
+	// Browser actions and artifacts apply to the first page only
+	nextTask := *task
+	nextTask.Options.Actions = nil
+	nextTask.Options.Artifacts = nil
+
+	for pages < maxPages {
+		doc, root := parsePaginationPage(task, page)
+
+		if pagination.StopIfMissing != "" && !pageHasMatch(doc, root, pagination.StopIfMissing) {
+			stopReason = "selector_missing"
+			break
+		}
+
+		nextURL, err := nextPageURL(task, mode, page, doc, root, start+pages*step)
+		if err != nil {
+			se.logger.WithError(err).WithField("task_id", task.TaskID).Warn("Failed to determine next page")
+			stopReason = "no_next_page"
+			break
+		}
+		if nextURL == "" {
+			stopReason = "no_next_page"
+			break
+		}
+		if visited[nextURL] {
+			stopReason = "repeated_url"
+			break
+		}
+		visited[nextURL] = true
+
+		next, err := se.fetchPage(&nextTask, nextURL)
+		if err != nil {
+			se.logger.WithError(err).WithFields(logrus.Fields{
+				"task_id": task.TaskID,
+				"url":     nextURL,
+			}).Warn("Failed to fetch next page, stopping pagination")
+			stopReason = "fetch_failed"
+			break
+		}
+		pages++
+
+		pageOutput, err := se.extractFromPage(task, next)
+		if err != nil {
+			se.logger.WithError(err).WithFields(logrus.Fields{
+				"task_id": task.TaskID,
+				"url":     nextURL,
+			}).Warn("Failed to extract next page, stopping pagination")
+			stopReason = "extraction_failed"
+			break
+		}
+
+		output.Pages = append(output.Pages, pageOutput.Pages...)
+
+		if mergePageData(output.Data, pageOutput.Data, seen) == 0 {
+			stopReason = "no_new_items"
+			break
+		}
+		page = next
+	}
+
+	output.Metadata["pages"] = pages
+	output.Metadata["pagination_stop"] = stopReason
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"pages":   pages,
+		"stop":    stopReason,
+	}).Info("Pagination completed")
+
+	return output, nil
+}
+
+// paginationMode returns the configured pagination mode, inferring it from
+// the options that are set
+func paginationMode(pagination *models.PaginationOptions) string {
+	if pagination.Mode != "" {
+		return strings.ToLower(pagination.Mode)
+	}
+	switch {
+	case pagination.NextSelector != "":
+		return "next"
+	case pagination.CursorPath != "":
+		return "cursor"
+	case strings.Contains(pagination.URLTemplate, "{offset}"):
+		return "offset"
+	default:
+		return "page"
+	}
+}
+
+// parsePaginationPage parses a page as HTML or as JSON, depending on its
+// response format, for evaluating pagination selectors
+func parsePaginationPage(task *models.TaskMessage, page *fetchedPage) (*goquery.Document, interface{}) {
+	if detectResponseFormat(task.Options.ResponseFormat, page) == "json" {
+		if root, err := decodeJSONBody(page.Body); err == nil {
+			return nil, root
+		}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// pageHasMatch reports whether a CSS selector (HTML pages) or JSON path
+// (JSON pages) matches anything on the page
+func pageHasMatch(doc *goquery.Document, root interface{}, selector string) bool {
+	if doc != nil {
+		return doc.Find(selector).Length() > 0
+	}
+	matches, _, err := evalJSONPath(root, selector)
+	return err == nil && len(matches) > 0 && !isEmptyList(matches[0])
+}
+
+// pageValue returns the first value matched by a CSS selector or JSON path.
+// For HTML the href, value or content attribute is preferred over the text.
+func pageValue(doc *goquery.Document, root interface{}, selector string) string {
+	if doc != nil {
+		selection := doc.Find(selector).First()
+		for _, attr := range []string{"href", "value", "content"} {
+			if value, ok := selection.Attr(attr); ok {
+				return strings.TrimSpace(value)
+			}
+		}
+		return strings.TrimSpace(selection.Text())
+	}
+
+	matches, _, err := evalJSONPath(root, selector)
+	if err != nil || len(matches) == 0 || matches[0] == nil {
+		return ""
+	}
+	return jsonText(matches[0])
+}
+
+// nextPageURL builds the URL of the following page, or returns an empty
+// string when there is none
+func nextPageURL(task *models.TaskMessage, mode string, page *fetchedPage, doc *goquery.Document, root interface{}, position int) (string, error) {
+	pagination := task.Options.Pagination
+
+	switch mode {
+	case "next":
+		href := pageValue(doc, root, pagination.NextSelector)
+		if href == "" {
+			return "", nil
+		}
+		base, err := url.Parse(page.URL)
+		if err != nil {
+			return "", fmt.Errorf("invalid page URL %s: %w", page.URL, err)
+		}
+		next, err := base.Parse(href)
+		if err != nil {
+			return "", fmt.Errorf("invalid next page link %s: %w", href, err)
+		}
+		return next.String(), nil
+	case "page", "offset":
+		return paginationURL(task.URL, pagination, mode, strconv.Itoa(position))
+	case "cursor":
+		cursor := pageValue(doc, root, pagination.CursorPath)
+		if cursor == "" {
+			return "", nil
+		}
+		return paginationURL(task.URL, pagination, mode, cursor)
+	default:
+		return "", fmt.Errorf("unsupported pagination mode: %s", mode)
+	}
+}
+
+// paginationURL fills the URL template placeholder, or sets the pagination
+// query parameter on the task URL
+func paginationURL(taskURL string, pagination *models.PaginationOptions, mode, value string) (string, error) {
+	if pagination.URLTemplate != "" {
+		return strings.NewReplacer(
+			"{page}", url.QueryEscape(value),
+			"{offset}", url.QueryEscape(value),
+			"{cursor}", url.QueryEscape(value),
+		).Replace(pagination.URLTemplate), nil
+	}
+
+	param := pagination.Param
+	if param == "" {
+		param = mode
+	}
+	parsed, err := url.Parse(taskURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	query := parsed.Query()
+	query.Set(param, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// mergePageData appends the list and items fields of a page to the merged
+// result and returns how many of the page's list values were not seen on
+// earlier pages. Only those values are appended, so items repeated across
+// overlapping pages appear once. Scalar fields keep their value from the
+// first page. A page without new values is not merged. With a nil page the
+// merged result itself is registered as seen.
+func mergePageData(merged, page map[string]interface{}, seen map[string]bool) int {
+	source := page
+	if source == nil {
+		source = merged
+	}
+
+	newValues := 0
+	pending := make(map[string]bool)
+	fresh := make(map[string][]interface{})
+	for key, value := range source {
+		for _, element := range listElements(value) {
+			encoded, err := json.Marshal(element)
+			if err != nil {
+				fresh[key] = append(fresh[key], element)
+				continue
+			}
+			id := key + "\x00" + string(encoded)
+			if seen[id] {
+				continue
+			}
+			if !pending[id] {
+				pending[id] = true
+				newValues++
+			}
+			fresh[key] = append(fresh[key], element)
+		}
+	}
+	for id := range pending {
+		seen[id] = true
+	}
+
+	if page == nil || newValues == 0 {
+		return newValues
+	}
+
+	for key, value := range page {
+		existing, exists := merged[key]
+		if !exists {
+			merged[key] = value
+			continue
+		}
+		merged[key] = appendList(existing, fresh[key])
+	}
+	return newValues
+}
+
+// largestList returns the length of the longest list field
+func largestList(data map[string]interface{}) int {
+	largest := 0
+	for _, value := range data {
+		if length := len(listElements(value)); length > largest {
+			largest = length
+		}
+	}
+	return largest
+}
+
+// listElements returns the elements of a list value, or nil for scalars
+func listElements(value interface{}) []interface{} {
+	switch typed := value.(type) {
+	case []interface{}:
+		return typed
+	case []string:
+		elements := make([]interface{}, len(typed))
+		for i, element := range typed {
+			elements[i] = element
+		}
+		return elements
+	case []map[string]interface{}:
+		elements := make([]interface{}, len(typed))
+		for i, element := range typed {
+			elements[i] = element
+		}
+		return elements
+	default:
+		return nil
+	}
+}
+
+// appendList appends page list elements to the merged list, keeping the
+// merged value unchanged for scalars and skipping elements of another type
+func appendList(existing interface{}, elements []interface{}) interface{} {
+	switch typed := existing.(type) {
+	case []string:
+		for _, element := range elements {
+			if text, ok := element.(string); ok {
+				typed = append(typed, text)
+			}
+		}
+		return typed
+	case []map[string]interface{}:
+		for _, element := range elements {
+			if item, ok := element.(map[string]interface{}); ok {
+				typed = append(typed, item)
+			}
+		}
+		return typed
+	case []interface{}:
+		return append(typed, elements...)
+	}
+	return existing
+}
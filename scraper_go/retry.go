@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+// maxRetryBackoff caps the wait between two attempts
+const maxRetryBackoff = time.Minute
+
+// httpStatusError is returned when a page responds with an error status
+type httpStatusError struct {
+	StatusCode int
+	URL        string
+	RetryAfter time.Duration // from the Retry-After header, if any
+	body       []byte        // checked for block page markers
+}
+
+// newHTTPStatusError creates the error for a Colly error response
+func newHTTPStatusError(r *colly.Response) *httpStatusError {
+	return &httpStatusError{
+		StatusCode: r.StatusCode,
+		URL:        r.Request.URL.String(),
+		RetryAfter: parseRetryAfter(r.Headers.Get("Retry-After")),
+		body:       r.Body,
+	}
+}
+
+// Error implements the error interface
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s from %s", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+// retryPolicy is the retry behaviour of one task
+type retryPolicy struct {
+	maxRetries  int
+	baseDelay   time.Duration
+	rotateProxy bool
+}
+
+// retryPolicy returns the task's retry policy. Tasks without max_retries use
+// DEFAULT_MAX_RETRIES, a negative value disables retries and MAX_RETRIES
+// caps the value.
+func (se *ScraperEngine) retryPolicy(task *models.TaskMessage) retryPolicy {
+	maxRetries := task.Options.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = se.config.DefaultMaxRetries
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	if se.config.MaxRetries > 0 && maxRetries > se.config.MaxRetries {
+		maxRetries = se.config.MaxRetries
+	}
+
+	baseDelay := se.config.RetryDelay
+	if task.Options.RetryDelay > 0 {
+		baseDelay = time.Duration(task.Options.RetryDelay) * time.Second
+	}
+
+	return retryPolicy{
+		maxRetries:  maxRetries,
+		baseDelay:   baseDelay,
+		rotateProxy: task.Options.RotateProxy,
+	}
+}
+
+// scrapeWithRetries runs scrape for the task, retrying retryable failures
+// with exponential backoff and jitter. Every attempt is recorded in the
+// output metadata as "attempts", which is also returned for failed tasks.
+// Waiting for a retry stops when ctx is done.
+func (se *ScraperEngine) scrapeWithRetries(ctx context.Context, task *models.TaskMessage, scrape func(*models.TaskMessage) (*ScrapeOutput, error)) (*ScrapeOutput, error) {
+	policy := se.retryPolicy(task)
+	var history []map[string]interface{}
+
+	for attempt := 1; ; attempt++ {
+		attemptTask := task
+		record := map[string]interface{}{"attempt": attempt}
+
+		// Switch to the next pool proxy on retries when requested
+		if attempt > 1 && policy.rotateProxy {
+			if proxyURL := se.config.NextPoolProxy(); proxyURL != "" {
+				rotated := *task
+				rotated.Options.ProxyURL = proxyURL
+				attemptTask = &rotated
+				record["proxy_rotated"] = true
+			}
+		}
+
+		start := time.Now()
+		output, err := scrape(attemptTask)
+		record["duration_ms"] = time.Since(start).Milliseconds()
+
+		if err == nil {
+			history = append(history, record)
+			return withAttempts(output, history), nil
+		}
+
+		record["error"] = err.Error()
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			record["status"] = statusErr.StatusCode
+		}
+		var blocked *blockedError
+		if errors.As(err, &blocked) && len(blocked.Steps) > 0 {
+			record["block_escalation"] = blocked.Steps
+		}
+		var actionErr *actionError
+		if errors.As(err, &actionErr) {
+			record["failed_action"] = actionErr.Index
+		}
+		retryable := retryableError(err)
+		record["retryable"] = retryable
+
+		if !retryable || attempt > policy.maxRetries {
+			history = append(history, record)
+			return withAttempts(output, history), err
+		}
+
+		delay := retryBackoff(policy.baseDelay, attempt, err)
+		record["delay_ms"] = delay.Milliseconds()
+		history = append(history, record)
+
+		se.logger.WithError(err).WithFields(logrus.Fields{
+			"task_id": task.TaskID,
+			"attempt": attempt,
+			"delay":   delay.String(),
+		}).Warn("Scrape attempt failed, retrying")
+		if err := sleepContext(ctx, delay); err != nil {
+			return withAttempts(output, history), err
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error once it is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withAttempts adds the attempt history to an output's metadata, creating an
+// output when the last attempt produced none
+func withAttempts(output *ScrapeOutput, history []map[string]interface{}) *ScrapeOutput {
+	if output == nil {
+		output = &ScrapeOutput{}
+	}
+	if output.Metadata == nil {
+		output.Metadata = make(map[string]interface{})
+	}
+	output.Metadata["attempts"] = history
+	output.Metadata["retries"] = len(history) - 1
+	return output
+}
+
+// retryBackoff returns the wait before the attempt following attempt: the
+// base delay doubled per attempt with equal jitter, or the server's
+// Retry-After when that is longer
+func retryBackoff(base time.Duration, attempt int, err error) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+		if delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
+	return delay
+}
+
+// retryableError reports whether an error is transient: timeouts, 408, 429
+// and 5xx responses, dropped connections and proxy failures. Other errors,
+// such as 404 responses, selector misses and robots.txt denials, are
+// permanent.
+func retryableError(err error) bool {
+	if err == nil || errors.Is(err, errSelectorMiss) || errors.Is(err, errRobotsDisallowed) {
+		return false
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= 500
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout) {
+		return true
+	}
+
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
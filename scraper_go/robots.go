@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/temoto/robotstxt"
+)
+
+// errRobotsDisallowed marks requests blocked by the site's robots.txt
+var errRobotsDisallowed = errors.New("robots_disallowed")
+
+// robotsMaxBodySize caps the robots.txt bytes read per host
+const robotsMaxBodySize = 512 * 1024
+
+// robotsRetryTTL is how long a robots.txt that failed to fetch or returned a
+// server error is cached before it is fetched again
+const robotsRetryTTL = time.Minute
+
+// robotsEntry is a cached robots.txt. ready is closed once it is fetched;
+// data and expires must not be read before.
+type robotsEntry struct {
+	ready   chan struct{}
+	data    *robotstxt.RobotsData
+	expires time.Time
+}
+
+// expired reports whether a fetched entry has outlived its TTL. Entries
+// still being fetched are never expired.
+func (e *robotsEntry) expired(now time.Time) bool {
+	select {
+	case <-e.ready:
+		return now.After(e.expires)
+	default:
+		return false
+	}
+}
+
+// RobotsCache fetches and caches robots.txt per host. It is shared by all
+// workers of the engine, and concurrent lookups of a host share one fetch.
+type RobotsCache struct {
+	ttl     time.Duration
+	client  *http.Client
+	logger  *logrus.Logger
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+// NewRobotsCache creates a robots.txt cache whose entries expire after ttl
+func NewRobotsCache(ttl time.Duration, logger *logrus.Logger) *RobotsCache {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &RobotsCache{
+		ttl:     ttl,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+// Allowed checks targetURL against the host's robots.txt for userAgent and
+// returns the Crawl-delay that applies to the agent. Disallowed URLs return
+// an error wrapping errRobotsDisallowed.
+func (rc *RobotsCache) Allowed(targetURL, userAgent string) (time.Duration, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Path == "/robots.txt" {
+		return 0, nil
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	data := rc.robots(u, userAgent)
+	if !data.TestAgent(path, userAgent) {
+		return 0, fmt.Errorf("%w: %s is disallowed by robots.txt", errRobotsDisallowed, targetURL)
+	}
+
+	return data.FindGroup(userAgent).CrawlDelay, nil
+}
+
+// robots returns the cached robots.txt of a host, fetching it when missing
+// or expired. Callers arriving while it is fetched wait for that fetch.
+func (rc *RobotsCache) robots(u *url.URL, userAgent string) *robotstxt.RobotsData {
+	key := u.Scheme + "://" + u.Host
+
+	rc.mu.Lock()
+	entry, ok := rc.entries[key]
+	if ok && !entry.expired(time.Now()) {
+		rc.mu.Unlock()
+		<-entry.ready
+		return entry.data
+	}
+	entry = &robotsEntry{ready: make(chan struct{})}
+	rc.entries[key] = entry
+	rc.mu.Unlock()
+
+	entry.data, entry.expires = rc.load(key+"/robots.txt", u.Host, userAgent)
+	close(entry.ready)
+	return entry.data
+}
+
+// load fetches a robots.txt and returns it with its expiry. A failed fetch
+// allows all paths and a server error disallows them, both only for
+// robotsRetryTTL so that a transient failure is not cached for long.
+func (rc *RobotsCache) load(robotsURL, host, userAgent string) (*robotstxt.RobotsData, time.Time) {
+	retry := robotsRetryTTL
+	if rc.ttl < retry {
+		retry = rc.ttl
+	}
+
+	data, status, err := rc.fetch(robotsURL, userAgent)
+	switch {
+	case err != nil:
+		rc.logger.WithError(err).WithField("host", host).Warn("Failed to fetch robots.txt, allowing all paths")
+		return &robotstxt.RobotsData{}, time.Now().Add(retry)
+	case status >= 500:
+		rc.logger.WithFields(logrus.Fields{
+			"host":   host,
+			"status": status,
+		}).Warn("robots.txt returned a server error, disallowing all paths")
+		return data, time.Now().Add(retry)
+	}
+	return data, time.Now().Add(rc.ttl)
+}
+
+// fetch downloads and parses a robots.txt file, returning it with the
+// response status. Following the robots exclusion protocol, 4xx responses
+// allow everything and 5xx responses disallow everything.
+func (rc *RobotsCache) fetch(robotsURL, userAgent string) (*robotstxt.RobotsData, int, error) {
+	req, err := http.NewRequest(http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxBodySize))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	data, err := robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+	return data, resp.StatusCode, err
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 
@@ -49,6 +52,18 @@ func NewS3Uploader(cfg *config.Config) (*S3Uploader, error) {
 	}, nil
 }
 
+// outputFormats are the result formats UploadResult supports
+var outputFormats = toSet([]string{"json", "html", "xml", "md", "markdown", "csv"})
+
+// validateOutputFormat checks the task's output format before anything is
+// fetched
+func validateOutputFormat(task *models.TaskMessage) error {
+	if format := task.Options.OutputFormat; format != "" && !outputFormats[strings.ToLower(format)] {
+		return fmt.Errorf("%w: unsupported output format %q", errInvalidTask, format)
+	}
+	return nil
+}
+
 // UploadResult uploads a scraping result to S3 in the specified format
 func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat string) (string, error) {
 	u.logger.WithFields(logrus.Fields{
@@ -70,7 +85,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	case "html":
 		htmlData, err := result.ToHTML()
 		if err != nil {
-			return "", fmt.Errorf("failed to convert to HTML: %w", err)
+			return "", fmt.Errorf("%w: failed to convert to HTML: %w", errUploadFailed, err)
 		}
 		data = []byte(htmlData)
 		contentType = "text/html"
@@ -79,7 +94,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	case "xml":
 		xmlData, err := result.ToXML()
 		if err != nil {
-			return "", fmt.Errorf("failed to convert to XML: %w", err)
+			return "", fmt.Errorf("%w: failed to convert to XML: %w", errUploadFailed, err)
 		}
 		data = []byte(xmlData)
 		contentType = "application/xml"
@@ -88,7 +103,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	case "md", "markdown":
 		mdData, err := result.ToMarkdown()
 		if err != nil {
-			return "", fmt.Errorf("failed to convert to Markdown: %w", err)
+			return "", fmt.Errorf("%w: failed to convert to Markdown: %w", errUploadFailed, err)
 		}
 		data = []byte(mdData)
 		contentType = "text/markdown"
@@ -97,7 +112,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	case "csv":
 		csvData, err := result.ToCSV()
 		if err != nil {
-			return "", fmt.Errorf("failed to convert to CSV: %w", err)
+			return "", fmt.Errorf("%w: failed to convert to CSV: %w", errUploadFailed, err)
 		}
 		data = []byte(csvData)
 		contentType = "text/csv"
@@ -106,7 +121,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	case "json":
 		jsonData, err := result.ToJSON()
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+			return "", fmt.Errorf("%w: failed to marshal result to JSON: %w", errUploadFailed, err)
 		}
 		data = []byte(jsonData)
 		contentType = "application/json"
@@ -136,7 +151,7 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %w", err)
+		return "", fmt.Errorf("%w: failed to upload to S3: %w", errUploadFailed, err)
 	}
 
 	// Generate S3 URL
@@ -151,6 +166,54 @@ func (u *S3Uploader) UploadResult(result *models.ScrapingResult, outputFormat st
 	return s3URL, nil
 }
 
+// UploadDataset uploads crawl records to S3 as NDJSON, one record per line
+func (u *S3Uploader) UploadDataset(taskID string, records []map[string]interface{}) (string, error) {
+	u.logger.WithFields(logrus.Fields{
+		"task_id": taskID,
+		"records": len(records),
+	}).Debug("Uploading dataset to S3")
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return "", fmt.Errorf("%w: failed to encode dataset record: %w", errUploadFailed, err)
+		}
+	}
+
+	// Create S3 key
+	key := fmt.Sprintf("datasets/%s/%s.ndjson",
+		time.Now().Format("2006/01/02"),
+		taskID)
+
+	// Upload to S3
+	_, err := u.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(u.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+		Metadata: map[string]*string{
+			"task_id":    aws.String(taskID),
+			"records":    aws.String(strconv.Itoa(len(records))),
+			"created_at": aws.String(time.Now().Format(time.RFC3339)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to upload dataset to S3: %w", errUploadFailed, err)
+	}
+
+	// Generate S3 URL
+	s3URL := fmt.Sprintf("s3://%s/%s", u.bucketName, key)
+
+	u.logger.WithFields(logrus.Fields{
+		"task_id": taskID,
+		"s3_url":  s3URL,
+		"records": len(records),
+	}).Info("Dataset uploaded to S3 successfully")
+
+	return s3URL, nil
+}
+
 // UploadRawData uploads raw data to S3 (for debugging or special cases)
 func (u *S3Uploader) UploadRawData(taskID, contentType string, data []byte) (string, error) {
 	u.logger.WithField("task_id", taskID).Debug("Uploading raw data to S3")
@@ -172,7 +235,7 @@ func (u *S3Uploader) UploadRawData(taskID, contentType string, data []byte) (str
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload raw data to S3: %w", err)
+		return "", fmt.Errorf("%w: failed to upload raw data to S3: %w", errUploadFailed, err)
 	}
 
 	// Generate S3 URL
@@ -186,6 +249,123 @@ func (u *S3Uploader) UploadRawData(taskID, contentType string, data []byte) (str
 	return s3URL, nil
 }
 
+// UploadArtifact uploads a screenshot or PDF of a task next to its result,
+// under results/YYYY/MM/DD/<task_id>/<fileName>
+func (u *S3Uploader) UploadArtifact(taskID, fileName, contentType string, data []byte) (string, error) {
+	u.logger.WithFields(logrus.Fields{
+		"task_id":  taskID,
+		"artifact": fileName,
+	}).Debug("Uploading artifact to S3")
+
+	// Create S3 key
+	key := fmt.Sprintf("results/%s/%s/%s",
+		time.Now().Format("2006/01/02"),
+		taskID, fileName)
+
+	// Upload to S3
+	_, err := u.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(u.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+		Metadata: map[string]*string{
+			"task_id":    aws.String(taskID),
+			"created_at": aws.String(time.Now().Format(time.RFC3339)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to upload artifact %s to S3: %w", errUploadFailed, fileName, err)
+	}
+
+	// Generate S3 URL
+	s3URL := fmt.Sprintf("s3://%s/%s", u.bucketName, key)
+
+	u.logger.WithFields(logrus.Fields{
+		"task_id": taskID,
+		"s3_url":  s3URL,
+	}).Info("Artifact uploaded to S3 successfully")
+
+	return s3URL, nil
+}
+
+// UploadPageArchive uploads the gzip-compressed raw pages of a task under
+// archives/YYYY/MM/DD/<task_id>.json.gz
+func (u *S3Uploader) UploadPageArchive(taskID string, data []byte) (string, error) {
+	u.logger.WithField("task_id", taskID).Debug("Uploading page archive to S3")
+
+	// Create S3 key
+	key := fmt.Sprintf("archives/%s/%s.json.gz",
+		time.Now().Format("2006/01/02"),
+		taskID)
+
+	// Upload to S3
+	_, err := u.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(u.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/gzip"),
+		Metadata: map[string]*string{
+			"task_id":    aws.String(taskID),
+			"created_at": aws.String(time.Now().Format(time.RFC3339)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to upload page archive to S3: %w", errUploadFailed, err)
+	}
+
+	// Generate S3 URL
+	s3URL := fmt.Sprintf("s3://%s/%s", u.bucketName, key)
+
+	u.logger.WithFields(logrus.Fields{
+		"task_id": taskID,
+		"s3_url":  s3URL,
+	}).Info("Page archive uploaded to S3 successfully")
+
+	return s3URL, nil
+}
+
+// DownloadArchive downloads a page archive given as s3://bucket/key or as a
+// key in the results bucket
+func (u *S3Uploader) DownloadArchive(location string) ([]byte, error) {
+	key, err := u.archiveKey(location)
+	if err != nil {
+		return nil, err
+	}
+
+	u.logger.WithFields(logrus.Fields{
+		"bucket": u.bucketName,
+		"key":    key,
+	}).Debug("Downloading page archive from S3")
+
+	output, err := u.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(u.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s from S3: %w", location, err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from S3: %w", location, err)
+	}
+	return data, nil
+}
+
+// archiveKey returns the key of an archive location. Tasks may only read
+// the archives/ prefix of the results bucket.
+func (u *S3Uploader) archiveKey(location string) (string, error) {
+	bucket, key := u.bucketName, location
+	if rest, ok := strings.CutPrefix(location, "s3://"); ok {
+		bucket, key, _ = strings.Cut(rest, "/")
+	}
+	if bucket != u.bucketName || !strings.HasPrefix(key, "archives/") || strings.Contains(key, "..") {
+		return "", fmt.Errorf("%w: archive must be under s3://%s/archives/: %s", errInvalidTask, u.bucketName, location)
+	}
+	return key, nil
+}
+
 // GetSignedURL generates a signed URL for accessing the result
 func (u *S3Uploader) GetSignedURL(s3Key string, expiration time.Duration) (string, error) {
 	req, _ := u.s3Client.GetObjectRequest(&s3.GetObjectInput{
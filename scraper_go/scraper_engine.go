@@ -1,26 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/debug"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
 	"scraper-go/config"
 	"scraper-go/models"
 )
 
 // ScraperEngine handles the actual scraping logic
 type ScraperEngine struct {
-	config *config.Config
-	logger *logrus.Logger
+	config   *config.Config
+	logger   *logrus.Logger
+	robots   *RobotsCache
+	limiter  *HostLimiter
+	engines  *EngineDecisionCache
+	browsers *BrowserPool
 }
 
 // NewScraperEngine creates a new scraper engine
@@ -32,98 +45,338 @@ func NewScraperEngine(cfg *config.Config) (*ScraperEngine, error) {
 	}
 
 	return &ScraperEngine{
-		config: cfg,
-		logger: logger,
+		config:   cfg,
+		logger:   logger,
+		robots:   NewRobotsCache(cfg.RobotsCacheTTL, logger),
+		limiter:  NewHostLimiter(cfg, logger),
+		engines:  NewEngineDecisionCache(cfg.AutoEngineTTL),
+		browsers: NewBrowserPool(cfg, logger),
 	}, nil
 }
 
-// Scrape performs the actual scraping based on the task message
-func (se *ScraperEngine) Scrape(task *models.TaskMessage) (map[string]interface{}, error) {
+// Close releases the engine's pooled browsers
+func (se *ScraperEngine) Close() {
+	se.browsers.Close()
+}
+
+// ScrapeOutput holds the data extracted by a scrape together with metadata
+// describing how it was produced
+type ScrapeOutput struct {
+	Data      map[string]interface{}
+	Metadata  map[string]interface{}
+	Artifacts []capturedArtifact // uploaded next to the result
+	Pages     []*fetchedPage     // raw pages, kept for archive_page tasks
+}
+
+// errSelectorMiss marks extraction errors caused by selectors matching nothing
+var errSelectorMiss = errors.New("selector_miss")
+
+// extractionReport collects schema fields that could not be extracted
+type extractionReport struct {
+	Missing         []string          // fields whose selectors matched nothing
+	Failed          map[string]string // fields that failed for another reason
+	RequiredMissing []string          // required fields without a value
+	seen            map[string]bool
+}
+
+// newExtractionReport creates an empty extraction report
+func newExtractionReport() *extractionReport {
+	return &extractionReport{
+		Failed: make(map[string]string),
+		seen:   make(map[string]bool),
+	}
+}
+
+// record adds a field failure to the report. Paths of fields inside items
+// are recorded once, not once per item.
+func (r *extractionReport) record(path string, err error, required bool) {
+	if r.seen[path] {
+		return
+	}
+	r.seen[path] = true
+
+	if errors.Is(err, errSelectorMiss) {
+		r.Missing = append(r.Missing, path)
+	} else {
+		r.Failed[path] = err.Error()
+	}
+	if required {
+		r.RequiredMissing = append(r.RequiredMissing, path)
+	}
+}
+
+// err returns a selector_miss error naming the required fields that could
+// not be extracted, or nil
+func (r *extractionReport) err() error {
+	if len(r.RequiredMissing) == 0 {
+		return nil
+	}
+	sort.Strings(r.RequiredMissing)
+	return fmt.Errorf("%w: required fields not found: %s", errSelectorMiss, strings.Join(r.RequiredMissing, ", "))
+}
+
+// metadata returns the report entries to include in the result metadata
+func (r *extractionReport) metadata() map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if len(r.Missing) > 0 {
+		sort.Strings(r.Missing)
+		metadata["missing_fields"] = r.Missing
+	}
+	if len(r.Failed) > 0 {
+		metadata["failed_fields"] = r.Failed
+	}
+	return metadata
+}
+
+// Scrape performs the actual scraping based on the task message. The output
+// is returned alongside extraction errors so its metadata can still be
+// reported.
+func (se *ScraperEngine) Scrape(task *models.TaskMessage) (*ScrapeOutput, error) {
+	return se.ScrapeContext(context.Background(), task)
+}
+
+// ScrapeContext is Scrape with a context that cuts retry waits short
+func (se *ScraperEngine) ScrapeContext(ctx context.Context, task *models.TaskMessage) (*ScrapeOutput, error) {
 	se.logger.WithFields(logrus.Fields{
 		"task_id": task.TaskID,
 		"url":     task.URL,
 		"js":      task.Options.EnableJS,
+		"engine":  task.Options.Engine,
 	}).Info("Starting scrape")
 
 	// Validate URL
-	if _, err := url.Parse(task.URL); err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+	if parsed, err := url.Parse(task.URL); err != nil {
+		return nil, fmt.Errorf("%w: invalid URL: %w", errInvalidTask, err)
+	} else if parsed.Host == "" {
+		return nil, fmt.Errorf("%w: invalid URL: %s", errInvalidTask, task.URL)
+	}
+	if err := validateActions(task); err != nil {
+		return nil, err
+	}
+	if err := validateInfiniteScroll(task); err != nil {
+		return nil, err
+	}
+	if err := validateArtifacts(task); err != nil {
+		return nil, err
+	}
+	if err := validateOutputFormat(task); err != nil {
+		return nil, err
+	}
+	if err := validateBlockEscalation(task); err != nil {
+		return nil, err
+	}
+
+	return se.scrapeWithRetries(ctx, task, se.scrapeOnce)
+}
+
+// scrapeOnce makes a single attempt at fetching and extracting the task
+func (se *ScraperEngine) scrapeOnce(task *models.TaskMessage) (*ScrapeOutput, error) {
+	switch task.Options.Engine {
+	case engineAuto:
+		return se.scrapeAuto(task)
+	case engineColly, engineChrome:
+		return se.scrapeOnce(withEngine(task, task.Options.Engine == engineChrome))
+	}
+	if task.Options.Pagination != nil {
+		return se.scrapePaginated(task, nil)
 	}
 
-	// Choose scraping method based on options
-	if task.Options.EnableJS {
-		return se.scrapeWithJS(task)
+	page, err := se.fetchPage(task, task.URL)
+	if err != nil {
+		return nil, err
 	}
-	return se.scrapeWithColly(task)
+
+	return se.extractFromPage(task, page)
 }
 
-// scrapeWithColly performs scraping using Colly (for HTML-only sites)
-func (se *ScraperEngine) scrapeWithColly(task *models.TaskMessage) (map[string]interface{}, error) {
-	se.logger.WithField("task_id", task.TaskID).Debug("Using Colly for scraping")
+// fetchedPage is a fetched response together with the details needed to
+// extract data from it
+type fetchedPage struct {
+	URL         string // final URL after redirects
+	StatusCode  int
+	ContentType string
+	Headers     http.Header
+	Body        []byte
+	Escalation  []map[string]interface{} // block escalation steps taken
+	Actions     []map[string]interface{} // browser actions run
+	Snapshots   []pageSnapshot           // pages captured by snapshot actions
+	Expansion   map[string]interface{}   // infinite scroll iterations
+	Artifacts   []capturedArtifact       // screenshots and PDFs to upload
+}
 
-	// Create a new collector
-	c := colly.NewCollector(
-		colly.Debugger(&debug.LogDebugger{}),
-	)
+// fetchPage fetches a URL with the engine selected by the task options
+func (se *ScraperEngine) fetchPage(task *models.TaskMessage, targetURL string) (*fetchedPage, error) {
+	if err := se.checkRobots(task, targetURL); err != nil {
+		return nil, err
+	}
 
-	// Set user agent
-	userAgent := task.Options.UserAgent
-	if userAgent == "" {
-		userAgent = se.config.DefaultUserAgent
+	// Without an escalation ladder a blocked error response takes the
+	// normal HTTP error and retry path
+	page, err := se.fetchWithEngine(task, targetURL)
+	if reason := detectBlock(page, err); reason != "" && (err == nil || len(task.Options.BlockEscalation) > 0) {
+		return se.escalateBlock(task, targetURL, reason, err)
 	}
-	c.UserAgent = userAgent
+	return page, err
+}
 
-	// Set timeout
-	timeout := task.Options.Timeout
-	if timeout == 0 {
-		timeout = se.config.DefaultTimeout
+// fetchWithEngine fetches a URL with Colly or Chrome as the task options ask
+func (se *ScraperEngine) fetchWithEngine(task *models.TaskMessage, targetURL string) (*fetchedPage, error) {
+	// Choose scraping method based on options. Colly requests are limited by
+	// the collector transport; each browser navigation holds one host slot.
+	if usesChrome(task) {
+		return se.scrapeWithJS(task, targetURL)
 	}
-	c.SetRequestTimeout(time.Duration(timeout) * time.Second)
+	return se.scrapeWithColly(task, targetURL)
+}
 
-	// Set proxy if provided
-	if task.Options.ProxyURL != "" {
-		if err := c.SetProxy(task.Options.ProxyURL); err != nil {
-			se.logger.WithError(err).Warn("Failed to set proxy, continuing without proxy")
-		}
-	} else if se.config.UseProxyRotation {
-		proxy := se.config.GetNextProxy()
-		if proxy != "" {
-			if err := c.SetProxy(proxy); err != nil {
-				se.logger.WithError(err).Warn("Failed to set rotating proxy, continuing without proxy")
+// navigate loads a URL in a Chrome tab, holding a host slot for the page
+// load only rather than for the whole browser session
+func (se *ScraperEngine) navigate(targetURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if u, err := url.Parse(targetURL); err == nil {
+			release, err := se.limiter.Acquire(ctx, u.Hostname())
+			if err != nil {
+				return err
 			}
+			defer release()
 		}
+		return chromedp.Navigate(targetURL).Do(ctx)
+	})
+}
+
+// checkRobots enforces robots.txt for tasks with respect_robots set: it
+// fails disallowed URLs with errRobotsDisallowed and hands the host's
+// Crawl-delay to the host limiter, which spaces the requests of all tasks
+func (se *ScraperEngine) checkRobots(task *models.TaskMessage, targetURL string) error {
+	if !task.Options.RespectRobots {
+		return nil
 	}
 
-	// Set headers
-	if task.Options.Headers != nil {
-		c.OnRequest(func(r *colly.Request) {
-			for key, value := range task.Options.Headers {
-				r.Headers.Set(key, value)
-			}
-		})
+	delay, err := se.robots.Allowed(targetURL, se.userAgent(task))
+	if err != nil {
+		return err
+	}
+	if u, err := url.Parse(targetURL); err == nil {
+		se.limiter.SetCrawlDelay(u.Hostname(), delay)
 	}
+	return nil
+}
 
-	// Respect robots.txt if enabled
-	if task.Options.RespectRobots {
-		c.OnRequest(func(r *colly.Request) {
-			r.Headers.Set("User-Agent", userAgent)
-		})
+// userAgent returns the user agent of a task
+func (se *ScraperEngine) userAgent(task *models.TaskMessage) string {
+	if task.Options.UserAgent != "" {
+		return task.Options.UserAgent
 	}
+	return se.config.DefaultUserAgent
+}
+
+// extractFromPage extracts the task schema from a fetched page, choosing the
+// extractor from the response format
+func (se *ScraperEngine) extractFromPage(task *models.TaskMessage, page *fetchedPage) (*ScrapeOutput, error) {
+	format := detectResponseFormat(task.Options.ResponseFormat, page)
 
 	var result map[string]interface{}
-	var scrapeError error
+	var report *extractionReport
+	var err error
 
-	// Set up the scraping logic
-	c.OnHTML("html", func(e *colly.HTMLElement) {
-		se.logger.WithField("task_id", task.TaskID).Debug("Processing HTML content")
-		
-		// Extract data based on schema
-		extractedData, err := se.extractDataFromHTML(e.DOM, task.Schema)
+	switch format {
+	case "json":
+		result, report, err = se.extractDataFromJSONBody(page.Body, task.Schema)
+	case "xml", "feed":
+		result, report, err = se.extractFromXML(page.Body, task.Schema, format == "feed")
+	default:
+		doc, parseErr := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+		if parseErr != nil {
+			return nil, fmt.Errorf("failed to parse HTML: %w", parseErr)
+		}
+		result, report, err = se.extractDataFromHTML(doc.Selection, task.Schema)
+	}
+	if result == nil {
 		if err != nil {
-			scrapeError = fmt.Errorf("failed to extract data: %w", err)
-			return
+			return nil, err
+		}
+		return nil, fmt.Errorf("no data extracted from URL")
+	}
+
+	output := &ScrapeOutput{Data: result, Metadata: report.metadata(), Artifacts: page.Artifacts}
+	if task.Options.ArchivePage {
+		output.Pages = []*fetchedPage{page}
+	}
+	output.Metadata["response_format"] = format
+	if len(page.Escalation) > 0 {
+		output.Metadata["block_escalation"] = page.Escalation
+	}
+	if len(page.Actions) > 0 {
+		output.Metadata["actions"] = page.Actions
+	}
+	if page.Expansion != nil {
+		output.Metadata["infinite_scroll"] = page.Expansion
+	}
+	if len(page.Snapshots) > 0 {
+		snapshots, snapshotErr := se.extractSnapshots(task, page.Snapshots)
+		if snapshotErr != nil && err == nil {
+			err = snapshotErr
+		}
+		output.Metadata["snapshots"] = snapshots
+	}
+	if err != nil {
+		return output, fmt.Errorf("failed to extract data: %w", err)
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id": task.TaskID,
+		"format":  format,
+		"fields":  len(result),
+	}).Info("Scraping completed successfully")
+
+	return output, nil
+}
+
+// detectResponseFormat resolves the response format of a page: an explicit
+// "html", "json", "xml" or "feed" option wins, otherwise the Content-Type
+// header and then the body itself decide
+func detectResponseFormat(option string, page *fetchedPage) string {
+	switch strings.ToLower(option) {
+	case "html", "json", "xml", "feed":
+		return strings.ToLower(option)
+	}
+
+	contentType := strings.ToLower(page.ContentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		return "json"
+	case strings.Contains(contentType, "xhtml"):
+		return "html"
+	case strings.Contains(contentType, "rss"), strings.Contains(contentType, "atom"), strings.Contains(contentType, "xml"):
+		return "xml"
+	}
+
+	trimmed := bytes.TrimSpace(page.Body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "json"
+	}
+	head := bytes.ToLower(trimmed[:min(len(trimmed), 512)])
+	for _, prefix := range []string{"<?xml", "<rss", "<feed", "<rdf:rdf"} {
+		if bytes.HasPrefix(head, []byte(prefix)) && !bytes.Contains(head, []byte("<html")) {
+			return "xml"
 		}
-		result = extractedData
+	}
+	return "html"
+}
+
+// scrapeWithColly fetches a page using Colly (for HTML-only sites and APIs)
+func (se *ScraperEngine) scrapeWithColly(task *models.TaskMessage, targetURL string) (*fetchedPage, error) {
+	se.logger.WithField("task_id", task.TaskID).Debug("Using Colly for scraping")
+
+	c := se.newCollector(task)
+
+	var page *fetchedPage
+	var scrapeError error
+
+	// Capture the response; extraction happens once the format is known
+	c.OnResponse(func(r *colly.Response) {
+		se.logger.WithField("task_id", task.TaskID).Debug("Processing response")
+		page = newFetchedPage(r)
 	})
 
 	// Handle errors
@@ -133,11 +386,18 @@ func (se *ScraperEngine) scrapeWithColly(task *models.TaskMessage) (map[string]i
 			"url":     r.Request.URL.String(),
 			"status":  r.StatusCode,
 		}).Error("Scraping error")
+		if r.StatusCode >= 400 {
+			err = newHTTPStatusError(r)
+		}
 		scrapeError = err
 	})
 
 	// Visit the URL
-	err := c.Visit(task.URL)
+	err := c.Visit(targetURL)
+	var statusErr *httpStatusError
+	if errors.As(scrapeError, &statusErr) {
+		return nil, statusErr
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to visit URL: %w", err)
 	}
@@ -146,20 +406,91 @@ func (se *ScraperEngine) scrapeWithColly(task *models.TaskMessage) (map[string]i
 		return nil, scrapeError
 	}
 
-	if result == nil {
-		return nil, fmt.Errorf("no data extracted from URL")
+	if page == nil {
+		return nil, fmt.Errorf("no response received from URL")
 	}
 
-	se.logger.WithFields(logrus.Fields{
-		"task_id": task.TaskID,
-		"fields":  len(result),
-	}).Info("Scraping completed successfully")
+	return page, nil
+}
+
+// newFetchedPage converts a Colly response into a fetched page
+func newFetchedPage(r *colly.Response) *fetchedPage {
+	return &fetchedPage{
+		URL:         r.Request.URL.String(),
+		StatusCode:  r.StatusCode,
+		ContentType: r.Headers.Get("Content-Type"),
+		Headers:     *r.Headers,
+		Body:        r.Body,
+	}
+}
+
+// fixedProxy returns a transport proxy function for a single proxy URL.
+// Colly's round robin switcher rewrites the request while the transport is
+// still reading it, which races once requests run concurrently.
+func fixedProxy(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %s", proxyURL)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// newCollector creates a Colly collector configured with the task's user
+// agent, timeout, proxy and headers
+func (se *ScraperEngine) newCollector(task *models.TaskMessage, options ...colly.CollectorOption) *colly.Collector {
+	// Create a new collector
+	c := colly.NewCollector(
+		append([]colly.CollectorOption{colly.Debugger(&debug.LogDebugger{})}, options...)...,
+	)
+
+	// Set user agent
+	c.UserAgent = se.userAgent(task)
+
+	// Set timeout
+	timeout := task.Options.Timeout
+	if timeout == 0 {
+		timeout = se.config.DefaultTimeout
+	}
+	c.SetRequestTimeout(time.Duration(timeout) * time.Second)
+
+	// Set proxy if provided. The transport is built here rather than through
+	// SetProxy so it can be wrapped by the host limiter.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if task.Options.ProxyURL != "" {
+		if proxyFunc, err := fixedProxy(task.Options.ProxyURL); err != nil {
+			se.logger.WithError(err).Warn("Failed to set proxy, continuing without proxy")
+		} else {
+			transport.Proxy = proxyFunc
+		}
+	} else if se.config.UseProxyRotation {
+		proxyURL := se.config.GetNextProxy()
+		if proxyURL != "" {
+			if proxyFunc, err := fixedProxy(proxyURL); err != nil {
+				se.logger.WithError(err).Warn("Failed to set rotating proxy, continuing without proxy")
+			} else {
+				transport.Proxy = proxyFunc
+			}
+		}
+	}
+	c.WithTransport(se.limiter.Transport(transport))
+
+	// Set headers
+	if task.Options.Headers != nil {
+		c.OnRequest(func(r *colly.Request) {
+			for key, value := range task.Options.Headers {
+				r.Headers.Set(key, value)
+			}
+		})
+	}
 
-	return result, nil
+	return c
 }
 
-// scrapeWithJS performs scraping using Chrome headless with stealth capabilities
-func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage) (map[string]interface{}, error) {
+// scrapeWithJS fetches a rendered page using Chrome headless with stealth capabilities
+func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage, targetURL string) (*fetchedPage, error) {
 	se.logger.WithField("task_id", task.TaskID).Debug("Using Chrome headless for scraping")
 
 	// Create context with timeout
@@ -167,65 +498,63 @@ func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage) (map[string]inte
 	if timeout == 0 {
 		timeout = se.config.DefaultTimeout
 	}
-	
-	// Chrome options for stealth mode
-	opts := []chromedp.ExecAllocatorOption{
-		chromedp.NoFirstRun,
-		chromedp.NoDefaultBrowserCheck,
-		chromedp.NoSandbox,
-		chromedp.DisableGPU,
-		chromedp.DisableDevShmUsage,
-	}
-	
-	// Stealth mode options
+
+	// Take a tab in its own incognito context on a pooled browser
+	lease, err := se.browsers.Acquire(task.Options.ProxyURL, task.Options.StealthMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire browser: %w", err)
+	}
+	defer lease.Release()
+
+	ctx, cancel := context.WithTimeout(lease.Ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	// Stealth mode settings are applied to the tab, the browser is shared
+	var setup []chromedp.Action
 	if task.Options.StealthMode || se.config.DefaultStealthMode {
 		userAgent := task.Options.UserAgent
 		if userAgent == "" {
 			userAgent = se.config.DefaultUserAgent
 		}
-		
+
 		viewportWidth := task.Options.ViewportWidth
 		if viewportWidth == 0 {
 			viewportWidth = se.config.DefaultViewportWidth
 		}
-		
+
 		viewportHeight := task.Options.ViewportHeight
 		if viewportHeight == 0 {
 			viewportHeight = se.config.DefaultViewportHeight
 		}
-		
-		opts = append(opts,
-			chromedp.UserAgent(userAgent),
-			chromedp.WindowSize(viewportWidth, viewportHeight),
-			chromedp.DisableWebSecurity,
-			chromedp.DisableFeatures("VizDisplayCompositor"),
+
+		setup = append(setup,
+			emulation.SetUserAgentOverride(userAgent),
+			chromedp.EmulateViewport(int64(viewportWidth), int64(viewportHeight)),
 		)
-		
+
+		var blocked []string
 		if task.Options.DisableImages {
-			opts = append(opts, chromedp.DisableImages)
+			blocked = append(blocked, "*.png", "*.jpg", "*.jpeg", "*.gif", "*.webp", "*.svg", "*.ico")
 		}
-		
 		if task.Options.DisableCSS {
-			opts = append(opts, chromedp.DisableCSS)
+			blocked = append(blocked, "*.css")
+		}
+		if len(blocked) > 0 {
+			setup = append(setup, network.SetBlockedURLS(blocked))
 		}
 	}
-	
-	ctx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer cancel()
-	
-	ctx, cancel = chromedp.NewContext(ctx, chromedp.WithLogf(se.logger.Debugf))
-	defer cancel()
-	
-	ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
-	defer cancel()
+	if err := chromedp.Run(ctx, setup...); err != nil {
+		return nil, fmt.Errorf("failed to set up Chrome tab: %w", err)
+	}
+	documentResponse := watchDocumentResponse(ctx)
 
 	var htmlContent string
 
 	// Set up Chrome actions
 	actions := []chromedp.Action{
-		chromedp.Navigate(task.URL),
+		se.navigate(targetURL),
 	}
-	
+
 	// Add random delay if enabled
 	if task.Options.RandomDelay {
 		minDelay := task.Options.MinDelay
@@ -236,13 +565,13 @@ func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage) (map[string]inte
 		if maxDelay == 0 {
 			maxDelay = se.config.DefaultMaxDelay
 		}
-		
+
 		if maxDelay > minDelay {
 			delay := time.Duration(minDelay+rand.Intn(maxDelay-minDelay)) * time.Second
 			actions = append(actions, chromedp.Sleep(delay))
 		}
 	}
-	
+
 	// Human behavior simulation
 	if task.Options.HumanBehavior {
 		actions = append(actions,
@@ -251,29 +580,29 @@ func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage) (map[string]inte
 			chromedp.ScrollIntoView("body"),
 		)
 	}
-	
+
 	// Wait for specific element if specified
 	if task.Options.WaitForElement != "" {
 		actions = append(actions, chromedp.WaitVisible(task.Options.WaitForElement))
 	} else {
 		actions = append(actions, chromedp.WaitVisible("body"))
 	}
-	
+
 	// Check for CAPTCHA
 	var captchaElement string
-	err := chromedp.Run(ctx, chromedp.Query("#captcha, .captcha, [data-captcha], .g-recaptcha", &captchaElement))
+	err = chromedp.Run(ctx, chromedp.Query("#captcha, .captcha, [data-captcha], .g-recaptcha", &captchaElement))
 	if err == nil && captchaElement != "" {
 		se.logger.WithField("task_id", task.TaskID).Info("CAPTCHA detected, attempting to solve")
-		
+
 		// Solve CAPTCHA if solver is configured
 		if task.Options.CaptchaSolver != "" {
 			solution, err := se.solveCaptcha(ctx, task)
 			if err != nil {
-				return nil, fmt.Errorf("failed to solve CAPTCHA: %w", err)
+				return nil, fmt.Errorf("%w: failed to solve CAPTCHA: %w", errCaptchaFailed, err)
 			}
-			
+
 			// Submit CAPTCHA solution
-			actions = append(actions, 
+			actions = append(actions,
 				chromedp.SendKeys("#captcha-input, .captcha-input", solution),
 				chromedp.Click("#captcha-submit, .captcha-submit"),
 				chromedp.WaitVisible(task.Options.WaitForElement),
@@ -281,184 +610,420 @@ func (se *ScraperEngine) scrapeWithJS(task *models.TaskMessage) (map[string]inte
 		}
 	}
 
-	// Get the HTML content
-	actions = append(actions, chromedp.OuterHTML("html", &htmlContent))
-
 	// Execute the actions
 	err = chromedp.Run(ctx, actions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run Chrome: %w", err)
 	}
 
-	// Parse the HTML content
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	// Run the task's scripted actions on the loaded page
+	steps, snapshots, err := se.runBrowserActions(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load more content of infinite scroll and "load more" pages
+	var expansion map[string]interface{}
+	if task.Options.InfiniteScroll != nil {
+		expansion, err = se.expandPage(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Capture the requested screenshots and PDFs
+	artifacts, err := se.captureArtifacts(ctx, task)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+		return nil, err
 	}
 
-	// Extract data based on schema
-	result, err := se.extractDataFromHTML(doc, task.Schema)
+	// Get the HTML content and the URL the browser ended up on
+	var finalURL string
+	err = chromedp.Run(ctx,
+		chromedp.OuterHTML("html", &htmlContent),
+		chromedp.Location(&finalURL),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to extract data: %w", err)
+		return nil, fmt.Errorf("failed to run Chrome: %w", err)
 	}
 
-	se.logger.WithFields(logrus.Fields{
-		"task_id": task.TaskID,
-		"fields":  len(result),
-	}).Info("JS scraping completed successfully")
+	se.logger.WithField("task_id", task.TaskID).Debug("JS page rendered successfully")
 
-	return result, nil
+	statusCode, headers := documentResponse()
+	return &fetchedPage{
+		URL:         finalURL,
+		StatusCode:  statusCode,
+		ContentType: "text/html",
+		Headers:     headers,
+		Body:        []byte(htmlContent),
+		Actions:     steps,
+		Snapshots:   snapshots,
+		Expansion:   expansion,
+		Artifacts:   artifacts,
+	}, nil
 }
 
-// extractDataFromHTML extracts data from HTML based on the provided schema
-func (se *ScraperEngine) extractDataFromHTML(doc *goquery.Document, schema map[string]interface{}) (map[string]interface{}, error) {
+// extractDataFromHTML extracts data from HTML based on the provided schema.
+// Fields that cannot be extracted are listed in the returned report; the
+// error is set when any of them is required.
+func (se *ScraperEngine) extractDataFromHTML(scope *goquery.Selection, schema map[string]interface{}) (map[string]interface{}, *extractionReport, error) {
+	report := newExtractionReport()
+	result := se.extractFields(scope, schema, "", report)
+	return result, report, report.err()
+}
+
+// fieldExtractor extracts one field from the current scope of a document
+type fieldExtractor func(fieldName string, config map[string]interface{}, path string) (interface{}, error)
+
+// extractFields evaluates a schema relative to scope, so nested schemas can
+// pass the element matched by their parent field
+func (se *ScraperEngine) extractFields(scope *goquery.Selection, schema map[string]interface{}, path string, report *extractionReport) map[string]interface{} {
+	return se.extractSchema(schema, path, report, func(fieldName string, config map[string]interface{}, fieldPath string) (interface{}, error) {
+		return se.extractField(scope, fieldName, config, fieldPath, report)
+	})
+}
+
+// extractSchema evaluates every field of a schema with the given extractor.
+// Fields that fail take their "default" value (or nil) and are recorded in
+// the report under path.
+func (se *ScraperEngine) extractSchema(schema map[string]interface{}, path string, report *extractionReport, extract fieldExtractor) map[string]interface{} {
 	result := make(map[string]interface{})
 
 	for fieldName, fieldConfig := range schema {
-		fieldData, err := se.extractField(doc, fieldName, fieldConfig)
-		if err != nil {
-			se.logger.WithError(err).WithField("field", fieldName).Warn("Failed to extract field")
+		fieldPath := fieldName
+		if path != "" {
+			fieldPath = path + "." + fieldName
+		}
+
+		configMap, ok := fieldConfig.(map[string]interface{})
+		if !ok {
+			report.record(fieldPath, fmt.Errorf("invalid field configuration for %s", fieldName), false)
 			result[fieldName] = nil
 			continue
 		}
+		required, _ := configMap["required"].(bool)
+
+		fieldData, err := extractWithFallbacks(fieldName, configMap, fieldPath, extract)
+		if err == nil && required && isEmptyList(fieldData) {
+			err = fmt.Errorf("%w: no elements found for field %s", errSelectorMiss, fieldName)
+		}
+		if err != nil {
+			se.logger.WithError(err).WithField("field", fieldPath).Warn("Failed to extract field")
+			report.record(fieldPath, err, required)
+			result[fieldName] = configMap["default"]
+			continue
+		}
 		result[fieldName] = fieldData
 	}
 
-	return result, nil
+	return result
 }
 
-// extractField extracts a single field based on its configuration
-func (se *ScraperEngine) extractField(doc *goquery.Document, fieldName string, fieldConfig interface{}) (interface{}, error) {
-	configMap, ok := fieldConfig.(map[string]interface{})
+// selectorKeys are the field options that locate a value in a document
+var selectorKeys = []string{"selector", "xpath", "path"}
+
+// extractWithFallbacks tries the field's own selector and then each entry of
+// its "selectors" (or "paths") fallback chain in order, returning the first
+// value found. Entries are selector strings or objects overriding selector
+// options (e.g. {"xpath": "..."}). An empty list counts as a miss while
+// fallbacks remain.
+func extractWithFallbacks(fieldName string, config map[string]interface{}, path string, extract fieldExtractor) (interface{}, error) {
+	fallbacks, ok := config["selectors"].([]interface{})
 	if !ok {
-		return nil, fmt.Errorf("invalid field configuration for %s", fieldName)
+		fallbacks, ok = config["paths"].([]interface{})
+	}
+	if !ok || len(fallbacks) == 0 {
+		return extract(fieldName, config, path)
 	}
 
-	selector, ok := configMap["selector"].(string)
-	if !ok {
-		return nil, fmt.Errorf("selector is required for field %s", fieldName)
+	var candidates []map[string]interface{}
+	for _, key := range selectorKeys {
+		if _, exists := config[key]; exists {
+			candidates = append(candidates, config)
+			break
+		}
+	}
+	for _, fallback := range fallbacks {
+		candidate := make(map[string]interface{}, len(config))
+		for key, value := range config {
+			candidate[key] = value
+		}
+		for _, key := range selectorKeys {
+			delete(candidate, key)
+		}
+		switch typed := fallback.(type) {
+		case string:
+			candidate["selector"] = typed
+		case map[string]interface{}:
+			for key, value := range typed {
+				candidate[key] = value
+			}
+		default:
+			return nil, fmt.Errorf("invalid fallback selector for %s: %v", fieldName, fallback)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	var lastErr error
+	for i, candidate := range candidates {
+		value, err := extract(fieldName, candidate, path)
+		if err == nil && isEmptyList(value) && i < len(candidates)-1 {
+			err = fmt.Errorf("%w: no elements found for field %s", errSelectorMiss, fieldName)
+		}
+		if err == nil {
+			return value, nil
+		}
+		lastErr = err
 	}
 
+	return nil, lastErr
+}
+
+// isEmptyList reports whether an extracted value is a list with no elements
+func isEmptyList(value interface{}) bool {
+	switch typed := value.(type) {
+	case []string:
+		return len(typed) == 0
+	case []interface{}:
+		return len(typed) == 0
+	case []map[string]interface{}:
+		return len(typed) == 0
+	default:
+		return false
+	}
+}
+
+// extractField extracts a single field based on its configuration
+func (se *ScraperEngine) extractField(scope *goquery.Selection, fieldName string, configMap map[string]interface{}, path string, report *extractionReport) (interface{}, error) {
 	fieldType, ok := configMap["type"].(string)
 	if !ok {
 		fieldType = "text" // Default to text extraction
 	}
 
+	// Structured data fields read well-known page blocks and take no selector
+	switch fieldType {
+	case "jsonld", "microdata", "opengraph", "meta":
+		result, err := se.extractStructuredData(scope, fieldType, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return se.postProcessField(result, configMap)
+	}
+
+	selector, ok := configMap["selector"].(string)
+	if xpath, isXPath := configMap["xpath"].(string); isXPath {
+		selector, ok = xpath, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("selector is required for field %s", fieldName)
+	}
+
 	var result interface{}
 	var err error
 
 	switch fieldType {
 	case "text":
-		result, err = se.extractText(doc, selector, configMap)
+		result, err = se.extractText(scope, selector, configMap)
 	case "html":
-		result, err = se.extractHTML(doc, selector, configMap)
+		result, err = se.extractHTML(scope, selector, configMap)
 	case "attr":
-		result, err = se.extractAttribute(doc, selector, configMap)
+		result, err = se.extractAttribute(scope, selector, configMap)
 	case "list":
-		result, err = se.extractList(doc, selector, configMap)
+		result, err = se.extractList(scope, selector, configMap)
+	case "object":
+		result, err = se.extractObject(scope, selector, configMap, path, report)
+	case "items":
+		result, err = se.extractItems(scope, selector, configMap, path, report)
+	case "table":
+		result, err = se.extractTable(scope, selector, configMap)
 	default:
 		return nil, fmt.Errorf("unsupported field type: %s", fieldType)
 	}
 
-	return result, err
+	if err != nil {
+		return nil, err
+	}
+
+	return se.postProcessField(result, configMap)
 }
 
-// extractText extracts text content from elements
-func (se *ScraperEngine) extractText(doc *goquery.Document, selector string, config map[string]interface{}) (string, error) {
-	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
-		// For now, just get the first match
-		if i == 0 {
-			text := strings.TrimSpace(s.Text())
-			// Apply any transformations if specified
-			if transform, ok := config["transform"].(string); ok {
-				text = se.applyTransform(text, transform)
+// postProcessField applies the field-level regex, then the transform
+// pipeline, then coercion to the declared output type
+func (se *ScraperEngine) postProcessField(value interface{}, config map[string]interface{}) (interface{}, error) {
+	var err error
+	if pattern, ok := config["regex"].(string); ok {
+		if value, err = se.applyRegex(value, pattern, config); err != nil {
+			return nil, err
+		}
+	}
+	if transform, unknown := unknownLegacyTransform(config); unknown {
+		se.logger.WithField("transform", transform).Warn("Unknown transform, passing the value through")
+	}
+	if value, err = applyTransforms(value, config); err != nil {
+		return nil, err
+	}
+	return coerceOutputType(value, config)
+}
+
+// findSelection resolves a field selector relative to scope. Selectors are
+// CSS by default; fields with selector_type "xpath" (or an "xpath" key) are
+// evaluated as XPath expressions against the same parsed document. An empty
+// or ":scope" selector addresses the scope element itself.
+//
+// XPath matches are limited to the scope and its descendants, and a leading
+// "//" is taken as ".//", so that fields of an item or object never pick up
+// elements elsewhere in the page.
+func (se *ScraperEngine) findSelection(scope *goquery.Selection, selector string, config map[string]interface{}) (*goquery.Selection, error) {
+	if selector == "" || selector == ":scope" {
+		return scope, nil
+	}
+
+	selectorType, _ := config["selector_type"].(string)
+	if _, ok := config["xpath"].(string); !ok && selectorType != "xpath" {
+		return scope.Find(selector), nil
+	}
+
+	if strings.HasPrefix(selector, "//") {
+		selector = "." + selector
+	}
+
+	var matched, attributes []*html.Node
+	for _, node := range scope.Nodes {
+		nodes, err := htmlquery.QueryAll(node, selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XPath expression %s: %w", selector, err)
+		}
+		for _, match := range nodes {
+			// Attribute values come back as detached nodes holding the value
+			if match.Parent == nil && match.Type == html.ElementNode {
+				attributes = append(attributes, match)
+			} else {
+				matched = append(matched, match)
 			}
-			// This is a bit hacky, but works for single value extraction
-			// In a real implementation, you'd want to return this properly
 		}
-	})
-	
+	}
+
+	return scope.FilterNodes(matched...).
+		AddSelection(scope.FindNodes(matched...)).
+		AddNodes(attributes...), nil
+}
+
+// extractText extracts text content from elements
+func (se *ScraperEngine) extractText(scope *goquery.Selection, selector string, config map[string]interface{}) (string, error) {
 	// Get the first match
-	selection := doc.Find(selector).First()
-	if selection.Length() == 0 {
-		return "", fmt.Errorf("no elements found for selector: %s", selector)
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return "", err
 	}
-	
-	text := strings.TrimSpace(selection.Text())
-	
-	// Apply transformation if specified
-	if transform, ok := config["transform"].(string); ok {
-		text = se.applyTransform(text, transform)
+	selection = selection.First()
+	if selection.Length() == 0 {
+		return "", fmt.Errorf("%w: no elements found for selector: %s", errSelectorMiss, selector)
 	}
-	
-	return text, nil
+
+	return strings.TrimSpace(selection.Text()), nil
 }
 
 // extractHTML extracts HTML content from elements
-func (se *ScraperEngine) extractHTML(doc *goquery.Document, selector string, config map[string]interface{}) (string, error) {
-	selection := doc.Find(selector).First()
+func (se *ScraperEngine) extractHTML(scope *goquery.Selection, selector string, config map[string]interface{}) (string, error) {
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return "", err
+	}
+	selection = selection.First()
 	if selection.Length() == 0 {
-		return "", fmt.Errorf("no elements found for selector: %s", selector)
+		return "", fmt.Errorf("%w: no elements found for selector: %s", errSelectorMiss, selector)
 	}
-	
+
 	html, err := selection.Html()
 	if err != nil {
 		return "", err
 	}
-	
+
 	return html, nil
 }
 
 // extractAttribute extracts attribute values from elements
-func (se *ScraperEngine) extractAttribute(doc *goquery.Document, selector string, config map[string]interface{}) (string, error) {
+func (se *ScraperEngine) extractAttribute(scope *goquery.Selection, selector string, config map[string]interface{}) (string, error) {
 	attrName, ok := config["attr"].(string)
 	if !ok {
 		return "", fmt.Errorf("attr is required for attribute extraction")
 	}
-	
-	selection := doc.Find(selector).First()
+
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return "", err
+	}
+	selection = selection.First()
 	if selection.Length() == 0 {
-		return "", fmt.Errorf("no elements found for selector: %s", selector)
+		return "", fmt.Errorf("%w: no elements found for selector: %s", errSelectorMiss, selector)
 	}
-	
+
 	attrValue, exists := selection.Attr(attrName)
 	if !exists {
-		return "", fmt.Errorf("attribute %s not found", attrName)
+		return "", fmt.Errorf("%w: attribute %s not found", errSelectorMiss, attrName)
 	}
-	
+
 	return attrValue, nil
 }
 
 // extractList extracts a list of values from elements
-func (se *ScraperEngine) extractList(doc *goquery.Document, selector string, config map[string]interface{}) ([]string, error) {
+func (se *ScraperEngine) extractList(scope *goquery.Selection, selector string, config map[string]interface{}) ([]string, error) {
 	var results []string
-	
-	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Each(func(i int, s *goquery.Selection) {
 		text := strings.TrimSpace(s.Text())
 		if text != "" {
-			// Apply transformation if specified
-			if transform, ok := config["transform"].(string); ok {
-				text = se.applyTransform(text, transform)
-			}
 			results = append(results, text)
 		}
 	})
-	
+
 	return results, nil
 }
 
-// applyTransform applies a transformation to the extracted text
-func (se *ScraperEngine) applyTransform(text, transform string) string {
-	switch transform {
-	case "lowercase":
-		return strings.ToLower(text)
-	case "uppercase":
-		return strings.ToUpper(text)
-	case "trim":
-		return strings.TrimSpace(text)
-	default:
-		return text
+// extractObject extracts a nested object by evaluating the "fields" schema
+// relative to the first element matched by selector
+func (se *ScraperEngine) extractObject(scope *goquery.Selection, selector string, config map[string]interface{}, path string, report *extractionReport) (map[string]interface{}, error) {
+	fields, ok := config["fields"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("fields is required for object extraction")
 	}
+
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return nil, err
+	}
+	selection = selection.First()
+	if selection.Length() == 0 {
+		return nil, fmt.Errorf("%w: no elements found for selector: %s", errSelectorMiss, selector)
+	}
+
+	return se.extractFields(selection, fields, path, report), nil
+}
+
+// extractItems extracts a list of records, evaluating the "fields" schema
+// relative to every element matched by the container selector
+func (se *ScraperEngine) extractItems(scope *goquery.Selection, selector string, config map[string]interface{}, path string, report *extractionReport) ([]map[string]interface{}, error) {
+	fields, ok := config["fields"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("fields is required for items extraction")
+	}
+
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]map[string]interface{}, 0, selection.Length())
+	selection.Each(func(i int, s *goquery.Selection) {
+		items = append(items, se.extractFields(s, fields, path+"[]", report))
+	})
+
+	return items, nil
 }
 
 // solveCaptcha handles CAPTCHA solving using configured service
@@ -469,19 +1034,19 @@ func (se *ScraperEngine) solveCaptcha(ctx context.Context, task *models.TaskMess
 	if err != nil {
 		return "", fmt.Errorf("failed to capture screenshot: %w", err)
 	}
-	
+
 	// Initialize CAPTCHA solver
 	var solver CaptchaSolver
 	captchaSolver := task.Options.CaptchaSolver
 	if captchaSolver == "" {
 		captchaSolver = se.config.DefaultCaptchaSolver
 	}
-	
+
 	apiKey := task.Options.CaptchaApiKey
 	if apiKey == "" {
 		apiKey = se.config.DefaultCaptchaApiKey
 	}
-	
+
 	switch captchaSolver {
 	case "2captcha":
 		if apiKey == "" {
@@ -498,13 +1063,13 @@ func (se *ScraperEngine) solveCaptcha(ctx context.Context, task *models.TaskMess
 	default:
 		return "", fmt.Errorf("unsupported CAPTCHA solver: %s", captchaSolver)
 	}
-	
+
 	// Solve CAPTCHA
 	solution, err := solver.SolveCaptcha(imageData, "image")
 	if err != nil {
 		return "", fmt.Errorf("CAPTCHA solving failed: %w", err)
 	}
-	
+
 	return solution, nil
 }
 
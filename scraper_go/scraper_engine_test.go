@@ -1,13 +1,55 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/PuerkitoBio/goquery"
 	"scraper-go/config"
 	"scraper-go/models"
 )
 
+// newTestEngine creates a scraper engine with a minimal configuration
+func newTestEngine(t *testing.T) *ScraperEngine {
+	t.Helper()
+
+	engine, err := NewScraperEngine(&config.Config{
+		DefaultUserAgent: "Test Agent",
+		DefaultTimeout:   30,
+		LogLevel:         "error",
+		LogFormat:        "text",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scraper engine: %v", err)
+	}
+	return engine
+}
+
+// parseTestHTML parses an HTML fixture into a goquery document
+func parseTestHTML(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("Failed to parse HTML: %v", err)
+	}
+	return doc
+}
+
 func TestScraperEngine_ExtractField(t *testing.T) {
 	// This is a basic test structure
 	// In a real implementation, you would need to set up proper test fixtures
@@ -37,6 +79,1747 @@ func TestScraperEngine_ExtractField(t *testing.T) {
 	}
 }
 
+func TestScraperEngine_ExtractNestedObject(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<h1>Widget</h1>
+		<div class="price"><span class="amount">19.99</span><span class="currency">EUR</span></div>
+		<span class="amount">outside</span>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"title": map[string]interface{}{"selector": "h1"},
+		"price": map[string]interface{}{
+			"selector": ".price",
+			"type":     "object",
+			"fields": map[string]interface{}{
+				"amount":   map[string]interface{}{"selector": ".amount"},
+				"currency": map[string]interface{}{"selector": ".currency", "transform": "lowercase"},
+			},
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	price, ok := data["price"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected price to be a nested object, got %T", data["price"])
+	}
+	if price["amount"] != "19.99" {
+		t.Errorf("Expected amount '19.99', got '%v'", price["amount"])
+	}
+	if price["currency"] != "eur" {
+		t.Errorf("Expected currency 'eur', got '%v'", price["currency"])
+	}
+	if data["title"] != "Widget" {
+		t.Errorf("Expected title 'Widget', got '%v'", data["title"])
+	}
+}
+
+func TestScraperEngine_ExtractItems(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body><ul>
+		<li class="card"><a href="/a">Alpha</a><span class="price">10</span></li>
+		<li class="card"><a href="/b">Beta</a></li>
+	</ul></body></html>`)
+
+	schema := map[string]interface{}{
+		"results": map[string]interface{}{
+			"selector": "li.card",
+			"type":     "items",
+			"fields": map[string]interface{}{
+				"title": map[string]interface{}{"selector": "a"},
+				"link":  map[string]interface{}{"selector": "a", "type": "attr", "attr": "href"},
+				"price": map[string]interface{}{"selector": ".price"},
+			},
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	items, ok := data["results"].([]map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected results to be a list of records, got %T", data["results"])
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(items))
+	}
+	if items[1]["title"] != "Beta" || items[1]["link"] != "/b" {
+		t.Errorf("Unexpected second item: %v", items[1])
+	}
+	if items[1]["price"] != nil {
+		t.Errorf("Expected missing price to be nil, got '%v'", items[1]["price"])
+	}
+}
+
+func TestScraperEngine_ExtractItemScope(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<a class="card" href="/a"><span>Alpha</span></a>
+		<a class="card" href="/b"><span>Beta</span></a>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"results": map[string]interface{}{
+			"selector": "a.card",
+			"type":     "items",
+			"fields": map[string]interface{}{
+				"link":  map[string]interface{}{"selector": "", "type": "attr", "attr": "href"},
+				"href":  map[string]interface{}{"selector": ":scope", "type": "attr", "attr": "href"},
+				"title": map[string]interface{}{"xpath": "//span"},
+				"self":  map[string]interface{}{"xpath": "./@href"},
+			},
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	items, _ := data["results"].([]map[string]interface{})
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(items))
+	}
+	for i, want := range []map[string]interface{}{
+		{"link": "/a", "href": "/a", "title": "Alpha", "self": "/a"},
+		{"link": "/b", "href": "/b", "title": "Beta", "self": "/b"},
+	} {
+		for field, value := range want {
+			if items[i][field] != value {
+				t.Errorf("Expected item %d %s '%v', got '%v'", i, field, value, items[i][field])
+			}
+		}
+	}
+}
+
+func TestScraperEngine_ExtractXPath(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body><table class="specs">
+		<tr><th>Weight</th><td>2kg</td></tr>
+		<tr><th>SKU code</th><td>AB-123</td></tr>
+	</table><a class="more" href="/specs">More</a></body></html>`)
+
+	schema := map[string]interface{}{
+		"sku": map[string]interface{}{
+			"xpath": "//th[contains(., 'SKU')]/following-sibling::td",
+		},
+		"link": map[string]interface{}{
+			"selector":      "//a[@class='more']/@href",
+			"selector_type": "xpath",
+		},
+		"specs": map[string]interface{}{
+			"selector": "table.specs",
+			"type":     "object",
+			"fields": map[string]interface{}{
+				"weight": map[string]interface{}{"xpath": ".//th[.='Weight']/following-sibling::td"},
+			},
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	if data["sku"] != "AB-123" {
+		t.Errorf("Expected sku 'AB-123', got '%v'", data["sku"])
+	}
+	if data["link"] != "/specs" {
+		t.Errorf("Expected link '/specs', got '%v'", data["link"])
+	}
+	specs, _ := data["specs"].(map[string]interface{})
+	if specs["weight"] != "2kg" {
+		t.Errorf("Expected weight '2kg', got '%v'", specs["weight"])
+	}
+}
+
+func TestScraperEngine_ExtractStructuredData(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><head>
+		<meta property="og:title" content="Widget">
+		<meta name="description" content="A fine widget">
+		<script type="application/ld+json">
+			{"@context": "https://schema.org", "@graph": [
+				{"@type": "Product", "name": "Widget", "offers": [{"@type": "Offer", "price": 19.99, "priceCurrency": "EUR"}]}
+			]}
+		</script>
+	</head><body>
+		<div itemscope itemtype="https://schema.org/Product">
+			<span itemprop="name">Widget</span>
+			<div itemprop="brand" itemscope itemtype="https://schema.org/Brand"><span itemprop="name">Acme</span></div>
+		</div>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"price":       map[string]interface{}{"type": "jsonld", "path": "Product.offers.price"},
+		"brand":       map[string]interface{}{"type": "microdata", "path": "Product.brand.name"},
+		"og":          map[string]interface{}{"type": "opengraph"},
+		"description": map[string]interface{}{"type": "meta", "name": "description"},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	if data["price"] != 19.99 {
+		t.Errorf("Expected price 19.99, got '%v'", data["price"])
+	}
+	if data["brand"] != "Acme" {
+		t.Errorf("Expected brand 'Acme', got '%v'", data["brand"])
+	}
+	og, _ := data["og"].(map[string]interface{})
+	if og["og:title"] != "Widget" {
+		t.Errorf("Expected og:title 'Widget', got '%v'", og["og:title"])
+	}
+	if data["description"] != "A fine widget" {
+		t.Errorf("Expected description 'A fine widget', got '%v'", data["description"])
+	}
+}
+
+func TestScraperEngine_ExtractRegex(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<p class="stock">In stock: 42 units</p>
+		<p class="dims">Size 10x20 cm, 30x40 cm</p>
+		<p class="sku">SKU: AB-123</p>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"stock":     map[string]interface{}{"selector": ".stock", "regex": `(\d+) units`},
+		"sizes":     map[string]interface{}{"selector": ".dims", "regex": `\d+x\d+`, "regex_mode": "all"},
+		"dims":      map[string]interface{}{"selector": ".dims", "regex": `(?P<w>\d+)x(?P<h>\d+)`},
+		"sku":       map[string]interface{}{"selector": ".sku", "transform": `regex:([A-Z]+-\d+)`},
+		"backorder": map[string]interface{}{"selector": ".stock", "regex": `backorder`},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	if data["stock"] != "42" {
+		t.Errorf("Expected stock '42', got '%v'", data["stock"])
+	}
+	if sizes, _ := data["sizes"].([]string); len(sizes) != 2 || sizes[1] != "30x40" {
+		t.Errorf("Expected sizes [10x20 30x40], got '%v'", data["sizes"])
+	}
+	if dims, _ := data["dims"].(map[string]interface{}); dims["w"] != "10" || dims["h"] != "20" {
+		t.Errorf("Expected named groups w=10 h=20, got '%v'", data["dims"])
+	}
+	if data["sku"] != "AB-123" {
+		t.Errorf("Expected sku 'AB-123', got '%v'", data["sku"])
+	}
+	if data["backorder"] != nil {
+		t.Errorf("Expected non-matching regex to yield nil, got '%v'", data["backorder"])
+	}
+}
+
+func TestScraperEngine_TransformPipeline(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<span class="price">1.234,50 €</span>
+		<span class="count">  12,345   reviews </span>
+		<span class="tags">red, green ,blue</span>
+		<span class="stock">In Stock</span>
+		<time>March 5, 2024</time>
+		<span class="net">NEW 12.99 VAT incl.</span>
+		<span class="swiss">CHF 1'234.50</span>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"price": map[string]interface{}{
+			"selector":   ".price",
+			"transforms": []interface{}{map[string]interface{}{"type": "currency", "locale": "de-DE"}},
+		},
+		"reviews": map[string]interface{}{
+			"selector":    ".count",
+			"transforms":  []interface{}{"collapse_whitespace", map[string]interface{}{"type": "number", "locale": "en"}},
+			"output_type": "int",
+		},
+		"tags": map[string]interface{}{
+			"selector": ".tags",
+			"transforms": []interface{}{
+				map[string]interface{}{"type": "split", "separator": ","},
+				"uppercase",
+				map[string]interface{}{"type": "join", "separator": "|"},
+			},
+		},
+		"in_stock": map[string]interface{}{
+			"selector":   ".stock",
+			"transforms": []interface{}{"lowercase", map[string]interface{}{"type": "boolean"}},
+		},
+		"published": map[string]interface{}{
+			"selector":   "time",
+			"transforms": []interface{}{map[string]interface{}{"type": "date", "layout": "January 2, 2006", "timezone": "UTC"}},
+		},
+		"rating": map[string]interface{}{
+			"selector":    ".count",
+			"regex":       `(\d+),\d+`,
+			"output_type": "float",
+		},
+		"label": map[string]interface{}{
+			"selector":  ".stock",
+			"transform": "titlecase",
+		},
+		"net": map[string]interface{}{
+			"selector":   ".net",
+			"transforms": []interface{}{"currency"},
+		},
+		"swiss": map[string]interface{}{
+			"selector":   ".swiss",
+			"transforms": []interface{}{map[string]interface{}{"type": "number", "locale": "de-CH"}},
+		},
+		"group": map[string]interface{}{
+			"selector":   ".count",
+			"transforms": []interface{}{map[string]interface{}{"type": "regex", "pattern": `(\d+)`, "group": 2}},
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+
+	price, _ := data["price"].(map[string]interface{})
+	if price["amount"] != 1234.5 || price["currency"] != "EUR" {
+		t.Errorf("Expected price {1234.5 EUR}, got '%v'", data["price"])
+	}
+	if data["reviews"] != int64(12345) {
+		t.Errorf("Expected reviews 12345, got '%v' (%T)", data["reviews"], data["reviews"])
+	}
+	if data["tags"] != "RED|GREEN|BLUE" {
+		t.Errorf("Expected tags 'RED|GREEN|BLUE', got '%v'", data["tags"])
+	}
+	if data["in_stock"] != true {
+		t.Errorf("Expected in_stock true, got '%v'", data["in_stock"])
+	}
+	if data["published"] != "2024-03-05T00:00:00Z" {
+		t.Errorf("Expected published '2024-03-05T00:00:00Z', got '%v'", data["published"])
+	}
+	if data["rating"] != 12.0 {
+		t.Errorf("Expected rating 12, got '%v'", data["rating"])
+	}
+	if data["label"] != "In Stock" {
+		t.Errorf("Expected unknown legacy transform to pass the value through, got '%v'", data["label"])
+	}
+	net, _ := data["net"].(map[string]interface{})
+	if net["amount"] != 12.99 || net["currency"] != "" {
+		t.Errorf("Expected net {12.99 ''}, got '%v'", data["net"])
+	}
+	if data["swiss"] != 1234.5 {
+		t.Errorf("Expected swiss 1234.5, got '%v'", data["swiss"])
+	}
+	if data["group"] != nil {
+		t.Errorf("Expected out of range regex group to fail the field, got '%v'", data["group"])
+	}
+}
+
+func TestScraperEngine_RequiredDefaultsAndFallbacks(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<h1 class="product-title">Widget</h1>
+		<ul><li class="card"><a>One</a></li><li class="card"></li></ul>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"title": map[string]interface{}{
+			"selector":  "h1.title",
+			"selectors": []interface{}{"h2.title", map[string]interface{}{"xpath": "//h1"}},
+			"required":  true,
+		},
+		"rating": map[string]interface{}{"selector": ".rating", "default": "n/a"},
+		"cards": map[string]interface{}{
+			"selector": "li.card",
+			"type":     "items",
+			"fields": map[string]interface{}{
+				"name": map[string]interface{}{"selector": "a"},
+			},
+		},
+	}
+
+	data, report, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if data["title"] != "Widget" {
+		t.Errorf("Expected fallback title 'Widget', got '%v'", data["title"])
+	}
+	if data["rating"] != "n/a" {
+		t.Errorf("Expected default rating 'n/a', got '%v'", data["rating"])
+	}
+
+	metadata := report.metadata()
+	missing, _ := metadata["missing_fields"].([]string)
+	if strings.Join(missing, ",") != "cards[].name,rating" {
+		t.Errorf("Unexpected missing fields: %v", missing)
+	}
+
+	schema["sku"] = map[string]interface{}{"selector": ".sku", "required": true}
+	_, _, err = engine.extractDataFromHTML(doc.Selection, schema)
+	if !errors.Is(err, errSelectorMiss) || !strings.Contains(err.Error(), "sku") {
+		t.Errorf("Expected selector_miss error naming sku, got %v", err)
+	}
+}
+
+func TestScraperEngine_ExtractFromJSONResponse(t *testing.T) {
+	engine := newTestEngine(t)
+	page := &fetchedPage{
+		URL:         "https://api.example.com/search",
+		ContentType: "application/json; charset=utf-8",
+		Body: []byte(`{"meta": {"total": 2}, "data": {"results": [
+			{"name": "Alpha", "price": {"amount": "10.50", "currency": "EUR"}, "tags": ["a", "b"]},
+			{"name": "Beta", "price": {"amount": "7", "currency": "EUR"}, "tags": []}
+		]}}`),
+	}
+	task := &models.TaskMessage{
+		TaskID: "json-1",
+		Schema: map[string]interface{}{
+			"total":      map[string]interface{}{"path": "$.meta.total"},
+			"first_name": map[string]interface{}{"path": "data.results.0.name"},
+			"names":      map[string]interface{}{"path": "$.data.results[*].name", "type": "list"},
+			"products": map[string]interface{}{
+				"path": "data.results",
+				"type": "items",
+				"fields": map[string]interface{}{
+					"name":  map[string]interface{}{"path": "name", "transforms": []interface{}{"uppercase"}},
+					"price": map[string]interface{}{"path": "price.amount", "output_type": "float"},
+				},
+			},
+		},
+	}
+
+	output, err := engine.extractFromPage(task, page)
+	if err != nil {
+		t.Fatalf("Failed to extract data: %v", err)
+	}
+	data := output.Data
+
+	if output.Metadata["response_format"] != "json" {
+		t.Errorf("Expected response_format 'json', got '%v'", output.Metadata["response_format"])
+	}
+	if data["total"] != 2.0 {
+		t.Errorf("Expected total 2, got '%v'", data["total"])
+	}
+	if data["first_name"] != "Alpha" {
+		t.Errorf("Expected first_name 'Alpha', got '%v'", data["first_name"])
+	}
+	if names, _ := data["names"].([]interface{}); len(names) != 2 || names[1] != "Beta" {
+		t.Errorf("Expected names [Alpha Beta], got '%v'", data["names"])
+	}
+	products, _ := data["products"].([]map[string]interface{})
+	if len(products) != 2 || products[0]["name"] != "ALPHA" || products[0]["price"] != 10.5 {
+		t.Errorf("Unexpected products: %v", data["products"])
+	}
+}
+
+func TestScraperEngine_ExtractFeeds(t *testing.T) {
+	engine := newTestEngine(t)
+
+	rss := &fetchedPage{
+		ContentType: "application/rss+xml",
+		Body: []byte(`<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Example News</title>
+    <link>https://example.com/</link>
+    <item>
+      <title>First story</title>
+      <link>https://example.com/first</link>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 +0000</pubDate>
+      <dc:creator>Jane Doe</dc:creator>
+      <description>Story body</description>
+    </item>
+  </channel>
+</rss>`),
+	}
+	output, err := engine.extractFromPage(&models.TaskMessage{TaskID: "rss-1"}, rss)
+	if err != nil {
+		t.Fatalf("Failed to extract RSS feed: %v", err)
+	}
+	items, _ := output.Data["items"].([]map[string]interface{})
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v", output.Data["items"])
+	}
+	if items[0]["title"] != "First story" || items[0]["author"] != "Jane Doe" || items[0]["published"] != "2006-01-02T15:04:05Z" {
+		t.Errorf("Unexpected RSS item: %v", items[0])
+	}
+
+	atom := &fetchedPage{
+		Body: []byte(`<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example Atom</title>
+  <entry>
+    <id>urn:1</id>
+    <title>Atom entry</title>
+    <link rel="alternate" href="https://example.com/atom-entry"/>
+    <updated>2024-03-01T10:00:00Z</updated>
+    <author><name>John Roe</name></author>
+  </entry>
+</feed>`),
+	}
+	task := &models.TaskMessage{
+		TaskID: "atom-1",
+		Schema: map[string]interface{}{
+			"title": map[string]interface{}{"xpath": "/feed/title"},
+			"entries": map[string]interface{}{
+				"xpath": "//entry",
+				"type":  "items",
+				"fields": map[string]interface{}{
+					"link":   map[string]interface{}{"xpath": "link", "type": "attr", "attr": "href"},
+					"author": map[string]interface{}{"xpath": "author/name"},
+				},
+			},
+		},
+	}
+	output, err = engine.extractFromPage(task, atom)
+	if err != nil {
+		t.Fatalf("Failed to extract Atom feed: %v", err)
+	}
+	if output.Metadata["response_format"] != "xml" {
+		t.Errorf("Expected response_format 'xml', got '%v'", output.Metadata["response_format"])
+	}
+	if output.Data["title"] != "Example Atom" {
+		t.Errorf("Expected title 'Example Atom', got '%v'", output.Data["title"])
+	}
+	entries, _ := output.Data["entries"].([]map[string]interface{})
+	if len(entries) != 1 || entries[0]["link"] != "https://example.com/atom-entry" || entries[0]["author"] != "John Roe" {
+		t.Errorf("Unexpected entries: %v", output.Data["entries"])
+	}
+}
+
+func TestScraperEngine_ExtractTable(t *testing.T) {
+	engine := newTestEngine(t)
+	doc := parseTestHTML(t, `<html><body>
+		<table id="specs">
+			<thead><tr><th>Model</th><th colspan="2">Price</th></tr></thead>
+			<tbody>
+				<tr><td rowspan="2">X100</td><td>10</td><td>EUR</td></tr>
+				<tr><td>12</td><td>USD</td></tr>
+				<tr><td>X200</td><td>20</td><td>EUR</td></tr>
+			</tbody>
+			<tfoot><tr><td>Total</td><td>42</td><td></td></tr></tfoot>
+		</table>
+	</body></html>`)
+
+	schema := map[string]interface{}{
+		"rows": map[string]interface{}{
+			"selector":    "#specs",
+			"type":        "table",
+			"columns":     map[string]interface{}{"Model": "model", "Price": "amount", "Price_2": "currency"},
+			"skip_footer": true,
+		},
+	}
+
+	data, _, err := engine.extractDataFromHTML(doc.Selection, schema)
+	if err != nil {
+		t.Fatalf("Failed to extract table: %v", err)
+	}
+
+	rows, _ := data["rows"].([]map[string]interface{})
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %v", data["rows"])
+	}
+	if rows[1]["model"] != "X100" || rows[1]["amount"] != "12" || rows[1]["currency"] != "USD" {
+		t.Errorf("Expected rowspan to be expanded, got %v", rows[1])
+	}
+	if rows[2]["model"] != "X200" {
+		t.Errorf("Expected last row X200, got %v", rows[2])
+	}
+}
+
+func TestScraperEngine_ScrapePaginated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+		}
+		next := ""
+		if nextPage := map[string]string{"1": "2", "2": "3"}[page]; nextPage != "" {
+			next = `<a class="next" href="?page=` + nextPage + `">Next</a>`
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><h1>Catalog</h1>
+			<div class="product"><span class="name">Item %[1]s-a</span></div>
+			<div class="product"><span class="name">Item %[1]s-b</span></div>
+			%[2]s
+		</body></html>`, page, next)
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "paginated-1",
+		URL:    server.URL + "/catalog",
+		Schema: map[string]interface{}{
+			"title": map[string]interface{}{"selector": "h1"},
+			"names": map[string]interface{}{"selector": ".product .name", "type": "list"},
+			"products": map[string]interface{}{
+				"selector": ".product",
+				"type":     "items",
+				"fields": map[string]interface{}{
+					"name": map[string]interface{}{"selector": ".name"},
+				},
+			},
+		},
+		Options: models.ScrapingOptions{
+			Pagination: &models.PaginationOptions{NextSelector: "a.next", MaxPages: 5},
+		},
+	}
+
+	output, err := engine.Scrape(task)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+
+	if output.Metadata["pages"] != 3 || output.Metadata["pagination_stop"] != "no_next_page" {
+		t.Errorf("Expected 3 pages ending with no_next_page, got %v", output.Metadata)
+	}
+	if output.Data["title"] != "Catalog" {
+		t.Errorf("Expected title 'Catalog', got '%v'", output.Data["title"])
+	}
+	names, _ := output.Data["names"].([]string)
+	if len(names) != 6 || names[5] != "Item 3-b" {
+		t.Errorf("Expected 6 merged names, got %v", output.Data["names"])
+	}
+	products, _ := output.Data["products"].([]map[string]interface{})
+	if len(products) != 6 {
+		t.Errorf("Expected 6 merged products, got %v", output.Data["products"])
+	}
+}
+
+func TestScraperEngine_ScrapePaginatedOverlap(t *testing.T) {
+	var firstPageHits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		items := map[string][]string{"": {"A", "B"}, "2": {"B", "C"}, "3": {"C", "D"}}[page]
+		next := map[string]string{"": "2", "2": "3"}[page]
+		if page == "" {
+			atomic.AddInt32(&firstPageHits, 1)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>")
+		for _, item := range items {
+			fmt.Fprintf(w, `<div class="product"><span class="name">Item %s</span></div>`, item)
+		}
+		if next != "" {
+			fmt.Fprintf(w, `<a class="next" href="?page=%s">Next</a>`, next)
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "paginated-overlap-1",
+		URL:    server.URL + "/catalog",
+		Schema: map[string]interface{}{
+			"names": map[string]interface{}{"selector": ".product .name", "type": "list"},
+			"products": map[string]interface{}{
+				"selector": ".product",
+				"type":     "items",
+				"fields": map[string]interface{}{
+					"name": map[string]interface{}{"selector": ".name"},
+				},
+			},
+		},
+		Options: models.ScrapingOptions{
+			Engine:     "auto",
+			Pagination: &models.PaginationOptions{NextSelector: "a.next", MaxPages: 5},
+		},
+	}
+
+	output, err := engine.Scrape(task)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+
+	if hits := atomic.LoadInt32(&firstPageHits); hits != 1 {
+		t.Errorf("Expected the first page to be fetched once, got %d fetches", hits)
+	}
+	names, _ := output.Data["names"].([]string)
+	if strings.Join(names, ",") != "Item A,Item B,Item C,Item D" {
+		t.Errorf("Expected each name once, got %v", output.Data["names"])
+	}
+	products, _ := output.Data["products"].([]map[string]interface{})
+	if len(products) != 4 {
+		t.Errorf("Expected 4 merged products, got %v", output.Data["products"])
+	}
+}
+
+func TestScraperEngine_Crawl(t *testing.T) {
+	pages := map[string]string{
+		"/":           `<a href="/products/1">One</a><a href="/products/2">Two</a><a href="/about">About</a>`,
+		"/products/1": `<h1>Product 1</h1><a href="/products/3">Three</a>`,
+		"/products/2": `<h1>Product 2</h1><a href="/">Home</a>`,
+		"/products/3": `<h1>Product 3</h1>`,
+		"/about":      `<h1>About</h1>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body>%s</body></html>", body)
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "crawl-1",
+		Type:   models.TaskTypeCrawl,
+		URL:    server.URL + "/",
+		Schema: map[string]interface{}{
+			"title": map[string]interface{}{"selector": "h1", "default": ""},
+		},
+		Options: models.ScrapingOptions{
+			Crawl: &models.CrawlOptions{
+				MaxDepth: 1,
+				Allow:    []string{`/products/\d+$`},
+			},
+		},
+	}
+
+	output, err := engine.Crawl(task)
+	if err != nil {
+		t.Fatalf("Failed to crawl: %v", err)
+	}
+
+	// The start page and the two products linked from it; product 3 is too deep
+	if len(output.Records) != 3 {
+		t.Fatalf("Expected 3 records, got %d: %v", len(output.Records), output.Records)
+	}
+	titles := make(map[string]bool)
+	for _, record := range output.Records {
+		data, _ := record["data"].(map[string]interface{})
+		titles[fmt.Sprint(data["title"])] = true
+	}
+	if !titles["Product 1"] || !titles["Product 2"] || titles["About"] || titles["Product 3"] {
+		t.Errorf("Unexpected crawled pages: %v", output.Records)
+	}
+	if output.Metadata["pages"] != 3 {
+		t.Errorf("Expected pages metadata 3, got %v", output.Metadata["pages"])
+	}
+}
+
+func TestScraperEngine_ExpandSitemap(t *testing.T) {
+	var server *httptest.Server
+	var productsFetches int32
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow: /admin\nSitemap: %s/sitemap_index.xml\n", server.URL)
+		case "/sitemap_index.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/products.xml</loc><lastmod>2024-05-01</lastmod></sitemap>
+  <sitemap><loc>%[1]s/archive.xml</loc><lastmod>2019-01-01</lastmod></sitemap>
+</sitemapindex>`, server.URL)
+		case "/products.xml":
+			// The first fetch fails transiently and is retried
+			if atomic.AddInt32(&productsFetches, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/products/1</loc><lastmod>2024-04-20T10:00:00+00:00</lastmod></url>
+  <url><loc>%[1]s/products/2</loc><lastmod>2023-01-01</lastmod></url>
+  <url><loc>%[1]s/blog/launch</loc><lastmod>2024-04-21</lastmod></url>
+</urlset>`, server.URL)
+		case "/products/1":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body><h1>Product 1</h1></body></html>")
+		default:
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "sitemap-1",
+		Type:   models.TaskTypeSitemap,
+		URL:    server.URL + "/",
+		Schema: map[string]interface{}{"name": map[string]interface{}{"selector": "h1"}},
+		Options: models.ScrapingOptions{
+			MaxRetries:    1,
+			RespectRobots: true,
+			ArchivePage:   true,
+			Sitemap: &models.SitemapOptions{
+				Include:      []string{"/products/"},
+				LastmodAfter: "2024-01-01",
+			},
+		},
+	}
+
+	expansion, err := engine.ExpandSitemap(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Failed to expand sitemap: %v", err)
+	}
+
+	if expansion.Sitemaps != 2 {
+		t.Errorf("Expected 2 sitemaps fetched (archive skipped by lastmod), got %d", expansion.Sitemaps)
+	}
+	if len(expansion.Entries) != 1 || expansion.Entries[0].Loc != server.URL+"/products/1" {
+		t.Errorf("Expected only /products/1, got %v", expansion.Entries)
+	}
+
+	if fetches := atomic.LoadInt32(&productsFetches); fetches != 2 {
+		t.Errorf("Expected the failing sitemap to be fetched twice, got %d", fetches)
+	}
+
+	child := task.ChildTask(0, expansion.Entries[0].Loc)
+	if child.TaskID != "sitemap-1-1" || child.ParentTaskID != "sitemap-1" || child.Type != models.TaskTypeScrape || child.Options.Sitemap != nil {
+		t.Errorf("Unexpected child task: %+v", child)
+	}
+
+	// Raw pages of scraped entries are stored per child task
+	var stored []string
+	output := engine.ScrapeEntries(context.Background(), task, expansion.Entries, func(child *models.TaskMessage, output *ScrapeOutput) error {
+		stored = append(stored, child.TaskID)
+		output.Metadata["page_archive"] = "s3://bucket/archives/" + child.TaskID + ".json.gz"
+		return nil
+	})
+	if len(stored) != 1 || stored[0] != "sitemap-1-1" {
+		t.Errorf("Expected the entry pages to be stored for sitemap-1-1, got %v", stored)
+	}
+	if len(output.Records) != 1 || output.Records[0]["page_archive"] != "s3://bucket/archives/sitemap-1-1.json.gz" {
+		t.Errorf("Expected the record to carry its page archive, got %v", output.Records)
+	}
+}
+
+func TestScraperEngine_RespectRobots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 0.05\n")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body><h1>Public</h1></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID:  "robots-1",
+		URL:     server.URL + "/private/page",
+		Schema:  map[string]interface{}{"title": map[string]interface{}{"selector": "h1"}},
+		Options: models.ScrapingOptions{RespectRobots: true},
+	}
+
+	if _, err := engine.Scrape(task); !errors.Is(err, errRobotsDisallowed) {
+		t.Fatalf("Expected robots_disallowed error, got %v", err)
+	}
+
+	task.URL = server.URL + "/public"
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		output, err := engine.Scrape(task)
+		if err != nil {
+			t.Fatalf("Failed to scrape allowed page: %v", err)
+		}
+		if output.Data["title"] != "Public" {
+			t.Errorf("Expected title 'Public', got '%v'", output.Data["title"])
+		}
+	}
+	// The second request waits for the 50ms crawl-delay
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected crawl-delay to space requests, took %v", elapsed)
+	}
+
+	// Without respect_robots the page is fetched
+	task.URL = server.URL + "/private/page"
+	task.Options.RespectRobots = false
+	if _, err := engine.Scrape(task); err != nil {
+		t.Errorf("Expected scrape without respect_robots to succeed, got %v", err)
+	}
+}
+
+func TestRobotsCache_ServerError(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cache := newTestEngine(t).robots
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cache.Allowed(server.URL+"/page", "test-agent")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if !errors.Is(err, errRobotsDisallowed) {
+			t.Errorf("Expected a 5xx robots.txt to disallow, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Expected concurrent lookups to share one fetch, got %d", n)
+	}
+
+	// Server errors are only cached briefly
+	entry := cache.entries[server.URL]
+	if ttl := time.Until(entry.expires); ttl > robotsRetryTTL {
+		t.Errorf("Expected a 5xx robots.txt to be cached for at most %v, got %v", robotsRetryTTL, ttl)
+	}
+}
+
+func TestScraperEngine_HostLimiter(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body><h1>Shop</h1></body></html>")
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	engine.config.HostMaxConcurrency = 4
+	engine.config.HostLimits = map[string]config.HostLimit{
+		"127.0.0.1": {MaxConcurrency: 1, MinDelay: 30 * time.Millisecond},
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task := &models.TaskMessage{
+				TaskID: fmt.Sprintf("limit-%d", i),
+				URL:    server.URL,
+				Schema: map[string]interface{}{"title": map[string]interface{}{"selector": "h1"}},
+			}
+			if _, err := engine.Scrape(task); err != nil {
+				t.Errorf("Expected queued task to succeed, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxInFlight != 1 {
+		t.Errorf("Expected at most 1 concurrent request, got %d", maxInFlight)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Expected min delay to space requests, took %v", elapsed)
+	}
+
+	var metrics strings.Builder
+	engine.limiter.WriteMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `scraper_go_host_requests_total{host="127.0.0.1"} 3`) {
+		t.Errorf("Expected request count in metrics, got:\n%s", metrics.String())
+	}
+	if !strings.Contains(metrics.String(), `scraper_go_host_limiter_waits_total{host="127.0.0.1"} 2`) {
+		t.Errorf("Expected two delayed requests in metrics, got:\n%s", metrics.String())
+	}
+
+	if limit := engine.config.HostLimitFor("www.127.0.0.1"); limit.MaxConcurrency != 1 || limit.MinDelay != 30*time.Millisecond {
+		t.Errorf("Expected subdomain to inherit the domain override, got %+v", limit)
+	}
+
+	// Idle hosts are forgotten, keeping their counts under host="other"
+	engine.limiter.mu.Lock()
+	engine.limiter.hosts["127.0.0.1"].used = time.Now().Add(-2 * hostIdleTTL)
+	engine.limiter.swept = time.Time{}
+	engine.limiter.mu.Unlock()
+	release, err := engine.limiter.Acquire(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Expected a free host slot, got %v", err)
+	}
+	release()
+	if _, ok := engine.limiter.hosts["127.0.0.1"]; ok {
+		t.Error("Expected the idle host to be evicted")
+	}
+	metrics.Reset()
+	engine.limiter.WriteMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `scraper_go_host_requests_total{host="other"} 3`) {
+		t.Errorf("Expected evicted requests under host=\"other\", got:\n%s", metrics.String())
+	}
+
+	// Requests waiting for a slot give up when their context ends
+	engine.config.HostLimits["busy.example"] = config.HostLimit{MaxConcurrency: 1}
+	release, err = engine.limiter.Acquire(context.Background(), "busy.example")
+	if err != nil {
+		t.Fatalf("Expected a free host slot, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := engine.limiter.Acquire(ctx, "busy.example"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+	release()
+	if engine.limiter.hosts["busy.example"].users != 0 {
+		t.Errorf("Expected no users left on the host, got %d", engine.limiter.hosts["busy.example"].users)
+	}
+	if limit := engine.config.HostLimitFor("example.com"); limit.MaxConcurrency != 4 {
+		t.Errorf("Expected global limit for other hosts, got %+v", limit)
+	}
+}
+
+func TestScraperEngine_ScrapeRetries(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		case count < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body><h1>Recovered</h1></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	engine.config.DefaultMaxRetries = 3
+	engine.config.RetryDelay = 10 * time.Millisecond
+
+	task := &models.TaskMessage{
+		TaskID: "retry-1",
+		URL:    server.URL + "/flaky",
+		Schema: map[string]interface{}{"title": map[string]interface{}{"selector": "h1"}},
+	}
+	output, err := engine.Scrape(task)
+	if err != nil {
+		t.Fatalf("Expected scrape to succeed after retries, got %v", err)
+	}
+	if output.Data["title"] != "Recovered" {
+		t.Errorf("Expected title 'Recovered', got '%v'", output.Data["title"])
+	}
+	attempts, _ := output.Metadata["attempts"].([]map[string]interface{})
+	if len(attempts) != 3 || output.Metadata["retries"] != 2 {
+		t.Fatalf("Expected 3 attempts and 2 retries, got %v", output.Metadata)
+	}
+	if attempts[0]["status"] != http.StatusServiceUnavailable || attempts[0]["retryable"] != true {
+		t.Errorf("Expected first attempt to record a retryable 503, got %v", attempts[0])
+	}
+
+	// Permanent errors are not retried
+	task.URL = server.URL + "/missing"
+	output, err = engine.Scrape(task)
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected HTTP 404 error, got %v", err)
+	}
+	if requests["/missing"] != 1 || output.Metadata["retries"] != 0 {
+		t.Errorf("Expected 404 to fail without retries, got %d requests", requests["/missing"])
+	}
+
+	// A cancelled context cuts the wait for the next attempt short
+	engine.config.RetryDelay = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	task.URL = server.URL + "/cancelled"
+	start := time.Now()
+	output, err = engine.ScrapeContext(ctx, task)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the retry wait to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second || output.Metadata["retries"] != 0 {
+		t.Errorf("Expected no retry after cancellation, took %v with %v", elapsed, output.Metadata)
+	}
+
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{&httpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&httpStatusError{StatusCode: http.StatusBadGateway}, true},
+		{&httpStatusError{StatusCode: http.StatusForbidden}, false},
+		{fmt.Errorf("failed to visit URL: %w", syscall.ECONNRESET), true},
+		{fmt.Errorf("failed to run Chrome: %w", context.DeadlineExceeded), true},
+		{&net.OpError{Op: "proxyconnect", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("%w: no elements found", errSelectorMiss), false},
+	}
+	for _, tc := range cases {
+		if got := retryableError(tc.err); got != tc.retryable {
+			t.Errorf("retryableError(%v) = %v, expected %v", tc.err, got, tc.retryable)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err        error
+		code       models.ErrorCode
+		retryable  bool
+		httpStatus int
+	}{
+		{&httpStatusError{StatusCode: http.StatusNotFound}, models.ErrorCodeHTTPStatus, false, 404},
+		{fmt.Errorf("failed to visit URL: %w", &httpStatusError{StatusCode: http.StatusServiceUnavailable}), models.ErrorCodeHTTPStatus, true, 503},
+		{fmt.Errorf("failed to run Chrome: %w", context.DeadlineExceeded), models.ErrorCodeTimeout, true, 0},
+		{fmt.Errorf("failed to visit URL: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), models.ErrorCodeNetwork, true, 0},
+		{fmt.Errorf("%w: required fields not found: price", errSelectorMiss), models.ErrorCodeSelectorMiss, false, 0},
+		{fmt.Errorf("%w: /admin is disallowed by robots.txt", errRobotsDisallowed), models.ErrorCodeRobotsDisallowed, false, 0},
+		{fmt.Errorf("%w: failed to solve CAPTCHA: %w", errCaptchaFailed, errors.New("no solver")), models.ErrorCodeCaptchaFailed, false, 0},
+		{fmt.Errorf("%w: failed to upload to S3: %w", errUploadFailed, syscall.ECONNRESET), models.ErrorCodeUploadFailed, true, 0},
+		{errors.New("no response received from URL"), models.ErrorCodeInternal, false, 0},
+	}
+	for _, tc := range cases {
+		info := classifyError(tc.err)
+		if info.Code != tc.code || info.Retryable != tc.retryable || info.HTTPStatus != tc.httpStatus {
+			t.Errorf("classifyError(%v) = %+v, expected %s/%v/%d", tc.err, info, tc.code, tc.retryable, tc.httpStatus)
+		}
+	}
+
+	engine := newTestEngine(t)
+	_, err := engine.Scrape(&models.TaskMessage{TaskID: "invalid-1", URL: "not a url"})
+	result := &models.ScrapingResult{TaskID: "invalid-1"}
+	recordError(result, err)
+	if result.Status != models.TaskStatusFailed || result.ErrorCode != models.ErrorCodeInvalidTask || result.Retryable {
+		t.Errorf("Expected non-retryable invalid_task failure, got %+v", result)
+	}
+
+	// Unsupported output formats are rejected before fetching
+	_, err = engine.Scrape(&models.TaskMessage{TaskID: "invalid-2", URL: "http://127.0.0.1:1/", Options: models.ScrapingOptions{OutputFormat: "yaml"}})
+	if !errors.Is(err, errInvalidTask) || !strings.Contains(err.Error(), `"yaml"`) {
+		t.Errorf("Expected an invalid_task error for the output format, got %v", err)
+	}
+
+	// Upload failures after a successful scrape are warnings
+	result = &models.ScrapingResult{TaskID: "warning-1", Status: models.TaskStatusCompleted}
+	recordWarning(result, fmt.Errorf("%w: failed to upload artifact", errUploadFailed))
+	if result.Status != models.TaskStatusCompleted || result.Error != "" || result.WarningCode != models.ErrorCodeUploadFailed {
+		t.Errorf("Expected completed result with an upload_failed warning, got %+v", result)
+	}
+}
+
+func TestScraperEngine_BlockEscalation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><head><title>Just a moment...</title></head><body>Checking your browser</body></html>")
+	}))
+	defer server.Close()
+
+	// The proxy answers every request itself with the real page
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body><h1>Price 10</h1></body></html>")
+	}))
+	defer proxyServer.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "blocked-1",
+		URL:    server.URL,
+		Schema: map[string]interface{}{"title": map[string]interface{}{"selector": "h1"}},
+		Options: models.ScrapingOptions{
+			MaxRetries:      -1,
+			BlockEscalation: []string{"rotate_proxy"},
+		},
+	}
+
+	// Without proxies the ladder is exhausted and the task fails as blocked
+	_, err := engine.Scrape(task)
+	var blocked *blockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("Expected blocked error, got %v", err)
+	}
+	if blocked.Reason != "cloudflare_challenge" || len(blocked.Steps) != 2 || blocked.Steps[1]["outcome"] != "skipped" {
+		t.Errorf("Unexpected escalation: %s %v", blocked.Reason, blocked.Steps)
+	}
+	if info := classifyError(err); info.Code != models.ErrorCodeBlocked || info.HTTPStatus != http.StatusServiceUnavailable {
+		t.Errorf("Expected blocked error code with status 503, got %+v", info)
+	}
+
+	// A rotated proxy gets through and the steps are recorded
+	engine.config.ProxyList = []string{proxyServer.URL}
+	output, err := engine.Scrape(task)
+	if err != nil {
+		t.Fatalf("Expected escalation to succeed, got %v", err)
+	}
+	if output.Data["title"] != "Price 10" {
+		t.Errorf("Expected title 'Price 10', got '%v'", output.Data["title"])
+	}
+	steps, _ := output.Metadata["block_escalation"].([]map[string]interface{})
+	if len(steps) != 2 || steps[1]["step"] != "rotate_proxy" || steps[1]["outcome"] != "ok" {
+		t.Errorf("Expected rotate_proxy step to succeed, got %v", steps)
+	}
+
+	cases := []struct {
+		page   *fetchedPage
+		reason string
+	}{
+		{&fetchedPage{StatusCode: 200, Body: []byte(`<html><script src="/challenge.js"></script></html>`)}, ""},
+		{&fetchedPage{StatusCode: 200, Body: []byte(`<html><div id="cf-chl-widget"></div></html>`)}, "cloudflare_challenge"},
+		{&fetchedPage{StatusCode: 200, Body: []byte(`<html><script src="/cdn-cgi/challenge-platform/scripts/jsd/main.js"></script><h1>Product</h1></html>`)}, ""},
+		{&fetchedPage{StatusCode: 200, Body: []byte(`<iframe src="https://geo.captcha-delivery.com/captcha/"></iframe>`)}, ""},
+		{&fetchedPage{StatusCode: 200, Body: []byte(`<html><body><h1>Product</h1></body></html>`)}, ""},
+	}
+	for _, tc := range cases {
+		if reason := detectBlock(tc.page, nil); reason != tc.reason {
+			t.Errorf("detectBlock(%s) = %q, expected %q", tc.page.Body, reason, tc.reason)
+		}
+	}
+	errorCases := []struct {
+		err    *httpStatusError
+		reason string
+	}{
+		{&httpStatusError{StatusCode: http.StatusForbidden}, "http_403"},
+		{&httpStatusError{StatusCode: http.StatusForbidden, body: []byte(`<iframe src="https://geo.captcha-delivery.com/captcha/"></iframe>`)}, "datadome_challenge"},
+		{&httpStatusError{StatusCode: http.StatusServiceUnavailable, body: []byte(`<html><script src="/challenge.js"></script></html>`)}, "small_body"},
+		{&httpStatusError{StatusCode: http.StatusServiceUnavailable, body: []byte(`<html><body>Maintenance</body></html>`)}, ""},
+	}
+	for _, tc := range errorCases {
+		if reason := detectBlock(nil, tc.err); reason != tc.reason {
+			t.Errorf("detectBlock(HTTP %d %s) = %q, expected %q", tc.err.StatusCode, tc.err.body, reason, tc.reason)
+		}
+	}
+
+	// Without an escalation ladder a 403 is a plain HTTP error
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	task.URL = forbidden.URL
+	task.Options.BlockEscalation = nil
+	_, err = engine.Scrape(task)
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || errors.As(err, &blocked) || classifyError(err).Code != models.ErrorCodeHTTPStatus {
+		t.Errorf("Expected a 403 without escalation to fail as http_status, got %v", err)
+	}
+
+	// Unknown steps are rejected before fetching, even when nothing is blocked
+	task.URL = "http://127.0.0.1:1/"
+	task.Options.BlockEscalation = []string{"solve_captcha"}
+	if _, err := engine.Scrape(task); !errors.Is(err, errInvalidTask) {
+		t.Errorf("Expected an unknown escalation step to fail as invalid_task, got %v", err)
+	}
+}
+
+func TestScraperEngine_AutoEngine(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body><h1>Server rendered</h1></body></html>")
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID:  "auto-1",
+		URL:     server.URL,
+		Schema:  map[string]interface{}{"title": map[string]interface{}{"selector": "h1", "required": true}},
+		Options: models.ScrapingOptions{Engine: "auto"},
+	}
+
+	for _, decision := range []string{"probe", "cached"} {
+		output, err := engine.Scrape(task)
+		if err != nil {
+			t.Fatalf("Failed to scrape with auto engine: %v", err)
+		}
+		if output.Data["title"] != "Server rendered" {
+			t.Errorf("Expected title 'Server rendered', got '%v'", output.Data["title"])
+		}
+		if output.Metadata["engine"] != "colly" || output.Metadata["engine_decision"] != decision {
+			t.Errorf("Expected colly engine from %s, got %v/%v", decision, output.Metadata["engine"], output.Metadata["engine_decision"])
+		}
+	}
+
+	// A failed probe falls back to Chrome, which is only remembered once it
+	// succeeds (Chrome is not available here)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	engine.engines = NewEngineDecisionCache(time.Hour)
+	task.URL = failing.URL
+	task.Options.MaxRetries = -1
+	output, err := engine.Scrape(task)
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expected the probe's HTTP 502 after the Chrome fallback failed, got %v", err)
+	}
+	if output.Metadata["engine_reason"] != "probe_failed" {
+		t.Errorf("Expected probe_failed engine reason, got %v", output.Metadata)
+	}
+	if decision, ok := engine.engines.Lookup("127.0.0.1"); ok {
+		t.Errorf("Expected no decision after Chrome failed, got %+v", decision)
+	}
+
+	cases := []struct {
+		name       string
+		body       string
+		waitFor    string
+		extractErr error
+		reason     string
+	}{
+		{"server rendered", "<html><body><h1>Title</h1><noscript>Please enable JavaScript for the best experience</noscript>" + strings.Repeat("<p>Product description text.</p>", 10) + "</body></html>", "", nil, ""},
+		{"spa shell", `<html><body><div id="root"></div><script src="/app.js"></script></body></html>`, "", nil, "spa_shell"},
+		{"noscript warning", "<html><body><noscript>You need to enable JavaScript to run this app.</noscript></body></html>", "", nil, "noscript_warning"},
+		{"wait selector", "<html><body><h1>Title</h1></body></html>", ".price", nil, "wait_selector_missing"},
+		{"required fields", "<html><body><h1>Title</h1></body></html>", "", fmt.Errorf("%w: required fields not found: price", errSelectorMiss), "required_fields_missing"},
+	}
+	for _, tc := range cases {
+		task := &models.TaskMessage{Options: models.ScrapingOptions{WaitForElement: tc.waitFor}}
+		page := &fetchedPage{ContentType: "text/html", Body: []byte(tc.body)}
+		if reason := needsJS(task, page, tc.extractErr); reason != tc.reason {
+			t.Errorf("%s: needsJS = %q, expected %q", tc.name, reason, tc.reason)
+		}
+	}
+}
+
+func TestScraperEngine_BrowserActions(t *testing.T) {
+	engine := newTestEngine(t)
+
+	invalid := []struct {
+		name    string
+		engine  string
+		actions []models.BrowserAction
+	}{
+		{"unknown type", "", []models.BrowserAction{{Type: "drag"}}},
+		{"click without selector", "", []models.BrowserAction{{Type: "wait_network_idle"}, {Type: "click"}}},
+		{"wait without duration", "", []models.BrowserAction{{Type: "wait_timeout"}}},
+		{"colly engine", engineColly, []models.BrowserAction{{Type: "scroll"}}},
+	}
+	for _, tc := range invalid {
+		task := &models.TaskMessage{TaskID: "actions", URL: "https://example.com"}
+		task.Options.Engine = tc.engine
+		task.Options.Actions = tc.actions
+		if _, err := engine.Scrape(task); !errors.Is(err, errInvalidTask) {
+			t.Errorf("%s: expected an invalid task error, got %v", tc.name, err)
+		}
+	}
+
+	task := &models.TaskMessage{TaskID: "actions", URL: "https://example.com"}
+	task.Options.Actions = []models.BrowserAction{{Type: "click", Selector: "#more"}, {Type: "snapshot"}}
+	if err := validateActions(task); err != nil {
+		t.Errorf("Expected valid actions, got %v", err)
+	}
+	if !usesChrome(task) || fetchEngine(task) != engineChrome {
+		t.Errorf("Expected tasks with actions to use Chrome")
+	}
+
+	if key := keyByName("Enter"); key != "\r" {
+		t.Errorf("Expected Enter to map to a carriage return, got %q", key)
+	}
+	if key := keyByName("a"); key != "a" {
+		t.Errorf("Expected single characters to be typed as is, got %q", key)
+	}
+
+	err := error(&actionError{Index: 1, Type: "click", Err: context.DeadlineExceeded})
+	if !strings.Contains(err.Error(), "action 1 (click)") || classifyError(err).Code != models.ErrorCodeTimeout {
+		t.Errorf("Expected the failing step in a timeout error, got %v (%s)", err, classifyError(err).Code)
+	}
+
+	task.Schema = map[string]interface{}{"title": map[string]interface{}{"selector": "h1"}}
+	snapshots, err := engine.extractSnapshots(task, []pageSnapshot{
+		{Name: "tab_1", URL: "https://example.com/#1", HTML: "<html><body><h1>First</h1></body></html>"},
+		{Name: "tab_2", URL: "https://example.com/#2", HTML: "<html><body><h1>Second</h1></body></html>"},
+	})
+	if err != nil {
+		t.Fatalf("Expected snapshots to be extracted, got %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[1]["name"] != "tab_2" || snapshots[1]["data"].(map[string]interface{})["title"] != "Second" {
+		t.Errorf("Unexpected snapshots: %v", snapshots)
+	}
+
+	// Navigate steps are checked against robots.txt before the browser runs them
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer server.Close()
+	task.Options.RespectRobots = true
+	task.Options.Actions = []models.BrowserAction{{Type: "navigate", URL: server.URL + "/private"}}
+	_, _, err = engine.runBrowserActions(context.Background(), task)
+	var actionErr *actionError
+	if !errors.As(err, &actionErr) || actionErr.Index != 0 || !errors.Is(err, errRobotsDisallowed) {
+		t.Errorf("Expected the navigate step to be disallowed by robots.txt, got %v", err)
+	}
+}
+
+func TestScraperEngine_InfiniteScrollOptions(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for _, tc := range []struct {
+		name   string
+		engine string
+		scroll models.InfiniteScrollOptions
+	}{
+		{"no selectors", "", models.InfiniteScrollOptions{MaxItems: 50}},
+		{"colly engine", engineColly, models.InfiniteScrollOptions{ItemSelector: ".item"}},
+	} {
+		task := &models.TaskMessage{TaskID: "scroll", URL: "https://example.com/feed"}
+		task.Options.Engine = tc.engine
+		task.Options.InfiniteScroll = &tc.scroll
+		if _, err := engine.Scrape(task); !errors.Is(err, errInvalidTask) {
+			t.Errorf("%s: expected an invalid task error, got %v", tc.name, err)
+		}
+	}
+
+	task := &models.TaskMessage{TaskID: "scroll", URL: "https://example.com/feed"}
+	task.Options.InfiniteScroll = &models.InfiniteScrollOptions{LoadMoreSelector: `button[data-action="more"]`}
+	if err := validateInfiniteScroll(task); err != nil {
+		t.Errorf("Expected a load more task to be valid, got %v", err)
+	}
+	if !browserOnly(task) || fetchEngine(task) != engineChrome {
+		t.Errorf("Expected infinite scroll tasks to use Chrome")
+	}
+	if script := loadMoreScript(`button[data-action="more"]`); !strings.Contains(script, `"button[data-action=\"more\"]"`) {
+		t.Errorf("Expected the selector to be quoted as a JavaScript string, got:\n%s", script)
+	}
+
+	now := time.Now()
+	if minTime(now, now.Add(time.Second)) != now || minTime(now.Add(time.Second), now) != now {
+		t.Errorf("Expected minTime to return the earlier time")
+	}
+}
+
+func TestScraperEngine_Artifacts(t *testing.T) {
+	engine := newTestEngine(t)
+
+	for _, tc := range []struct {
+		name      string
+		artifacts []models.ArtifactRequest
+	}{
+		{"unknown type", []models.ArtifactRequest{{Type: "video"}}},
+		{"element without selector", []models.ArtifactRequest{{Type: "element_screenshot"}}},
+		{"unsafe name", []models.ArtifactRequest{{Type: "pdf", Name: "../receipt"}}},
+	} {
+		task := &models.TaskMessage{TaskID: "artifacts", URL: "https://example.com/product"}
+		task.Options.Artifacts = tc.artifacts
+		if _, err := engine.Scrape(task); !errors.Is(err, errInvalidTask) {
+			t.Errorf("%s: expected an invalid task error, got %v", tc.name, err)
+		}
+	}
+
+	got := artifactNames([]models.ArtifactRequest{
+		{Type: "full_page_screenshot"},
+		{Type: "element_screenshot", Selector: ".price", Name: "price"},
+		{Type: "full_page_screenshot"},
+		{Type: "pdf"},
+	})
+	if strings.Join(got, ",") != "full_page_screenshot,price,full_page_screenshot_2,pdf" {
+		t.Errorf("Unexpected artifact names: %v", got)
+	}
+
+	// Numbered names do not collide with requested ones
+	got = artifactNames([]models.ArtifactRequest{
+		{Type: "pdf"},
+		{Type: "pdf"},
+		{Type: "full_page_screenshot", Name: "pdf_2"},
+		{Type: "element_screenshot", Selector: ".price", Name: "price"},
+		{Type: "element_screenshot", Selector: ".old-price", Name: "price"},
+	})
+	if strings.Join(got, ",") != "pdf,pdf_3,pdf_2,price,price_2" {
+		t.Errorf("Unexpected artifact names: %v", got)
+	}
+	if artifactExtension("application/pdf") != "pdf" || artifactExtension("image/png") != "png" {
+		t.Errorf("Unexpected artifact extensions")
+	}
+
+	artifact := models.Artifact{Name: "price", Type: "element_screenshot", S3Location: "s3://bucket/results/2024/01/02/artifacts/price.png"}
+	result := &models.ScrapingResult{
+		TaskID:   "artifacts",
+		Status:   models.TaskStatusCompleted,
+		Metadata: map[string]interface{}{"artifacts": []models.Artifact{artifact}},
+	}
+	if update := newStatusUpdate(result); len(update.Artifacts) != 1 || update.Artifacts[0] != artifact {
+		t.Errorf("Expected the artifacts in the status update, got %+v", update.Artifacts)
+	}
+}
+
+func TestScraperEngine_ArchivePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next := ""
+		if r.URL.Query().Get("page") == "" {
+			next = `<a class="next" href="?page=2">Next</a>`
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("X-Cache", "HIT")
+		fmt.Fprintf(w, `<html><body><h1>Catalog</h1><span class="name">Item %s</span>%s</body></html>`, r.URL.RawQuery, next)
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t)
+	task := &models.TaskMessage{
+		TaskID: "archive-1",
+		URL:    server.URL + "/catalog",
+		Schema: map[string]interface{}{"names": map[string]interface{}{"selector": ".name", "type": "list"}},
+		Options: models.ScrapingOptions{
+			ArchivePage: true,
+			Pagination:  &models.PaginationOptions{NextSelector: "a.next", MaxPages: 2},
+		},
+	}
+
+	output, err := engine.Scrape(task)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+	if len(output.Pages) != 2 {
+		t.Fatalf("Expected both pages to be kept for the archive, got %d", len(output.Pages))
+	}
+
+	data, err := encodePageArchive(task, output.Pages)
+	if err != nil {
+		t.Fatalf("Failed to encode archive: %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected a gzip archive: %v", err)
+	}
+	var archive pageArchive
+	if err := json.NewDecoder(reader).Decode(&archive); err != nil {
+		t.Fatalf("Failed to decode archive: %v", err)
+	}
+
+	if archive.TaskID != "archive-1" || archive.URL != task.URL || len(archive.Pages) != 2 {
+		t.Fatalf("Unexpected archive: %+v", archive)
+	}
+	first := archive.Pages[0]
+	if first.URL != task.URL || first.StatusCode != http.StatusOK || first.Headers.Get("X-Cache") != "HIT" {
+		t.Errorf("Expected the final URL, status and headers of the first page, got %+v", first)
+	}
+	if !strings.Contains(string(first.Body), `<a class="next"`) || !strings.Contains(string(archive.Pages[1].Body), "page=2") {
+		t.Errorf("Expected the raw bodies of both pages")
+	}
+
+	task.Options.ArchivePage = false
+	if output, err := engine.Scrape(task); err != nil || len(output.Pages) != 0 {
+		t.Errorf("Expected no pages kept without archive_page, got %d (%v)", len(output.Pages), err)
+	}
+}
+
+func TestScraperEngine_Reextract(t *testing.T) {
+	engine := newTestEngine(t)
+	original := &models.TaskMessage{TaskID: "archived-1", URL: "https://example.com/catalog"}
+	data, err := encodePageArchive(original, []*fetchedPage{
+		{URL: "https://example.com/catalog", StatusCode: 200, ContentType: "text/html",
+			Body: []byte(`<html><body><h1>Catalog</h1><span class="price">10</span><span class="price">12</span></body></html>`)},
+		{URL: "https://example.com/catalog?page=2", StatusCode: 200, ContentType: "text/html",
+			Body: []byte(`<html><body><h1>Catalog</h1><span class="price">15</span></body></html>`)},
+		{URL: "https://example.com/catalog?page=3", StatusCode: 200, ContentType: "text/html",
+			Body: []byte(`<html><body><p>Moved</p></body></html>`)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode archive: %v", err)
+	}
+
+	// Local archives are only read from ARCHIVE_LOCAL_DIR
+	dir := t.TempDir()
+	path := dir + "/archived-1.json.gz"
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	processor := &JobProcessor{config: &config.Config{}, scraperEngine: engine}
+	task := &models.TaskMessage{
+		TaskID:  "reextract-1",
+		Type:    models.TaskTypeReextract,
+		Archive: "file://" + path,
+		Schema: map[string]interface{}{
+			"title":  map[string]interface{}{"selector": "h1", "required": true},
+			"prices": map[string]interface{}{"selector": ".price", "type": "list"},
+		},
+	}
+	if _, err := processor.loadPageArchive(task); !errors.Is(err, errInvalidTask) {
+		t.Errorf("Expected local archives to be disabled without ARCHIVE_LOCAL_DIR, got %v", err)
+	}
+	processor.config.ArchiveLocalDir = dir
+	task.Archive = "file://" + dir + "/../archived-1.json.gz"
+	if _, err := processor.loadPageArchive(task); !errors.Is(err, errInvalidTask) {
+		t.Errorf("Expected archives outside ARCHIVE_LOCAL_DIR to be refused, got %v", err)
+	}
+	outside := t.TempDir() + "/secret.json.gz"
+	if err := os.WriteFile(outside, data, 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if err := os.Symlink(outside, dir+"/link.json.gz"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	task.Archive = dir + "/link.json.gz"
+	if _, err := processor.loadPageArchive(task); !errors.Is(err, errInvalidTask) {
+		t.Errorf("Expected symlinks out of ARCHIVE_LOCAL_DIR to be refused, got %v", err)
+	}
+	task.Archive = path
+
+	result := &models.ScrapingResult{TaskID: task.TaskID}
+	output, err := processor.reextract(task, result)
+	if err != nil {
+		t.Fatalf("Failed to re-extract: %v", err)
+	}
+	if result.URL != original.URL {
+		t.Errorf("Expected the archived URL on the result, got %q", result.URL)
+	}
+	if output.Data["title"] != "Catalog" || fmt.Sprint(output.Data["prices"]) != "[10 12 15]" {
+		t.Errorf("Expected data merged across archived pages, got %v", output.Data)
+	}
+	if output.Metadata["pages"] != 3 || output.Metadata["archive"] != path {
+		t.Errorf("Expected archive metadata, got %v", output.Metadata)
+	}
+
+	// Anything that is not an archive is a single raw page
+	archive, err := decodePageArchive([]byte("<html><body><h1>Raw</h1></body></html>"), "https://example.com/raw")
+	if err != nil || len(archive.Pages) != 1 || archive.Pages[0].URL != "https://example.com/raw" {
+		t.Fatalf("Expected a raw page archive, got %+v (%v)", archive, err)
+	}
+	output, err = engine.Reextract(task, archive)
+	if err != nil || output.Data["title"] != "Raw" {
+		t.Errorf("Expected the raw page to be extracted, got %v (%v)", output, err)
+	}
+	if _, ok := output.Metadata["archived_at"]; ok {
+		t.Errorf("Expected no archived_at for a raw page, got %v", output.Metadata)
+	}
+
+	// S3 archives are only read from the archives of the results bucket
+	uploader := &S3Uploader{bucketName: "results"}
+	for location, valid := range map[string]bool{
+		"s3://results/archives/2024/01/02/archived-1.json.gz": true,
+		"archives/2024/01/02/archived-1.json.gz":              true,
+		"s3://other/archives/archived-1.json.gz":              false,
+		"s3://results/results/2024/01/02/archived-1.json":     false,
+		"archives/../results/archived-1.json":                 false,
+	} {
+		if _, err := uploader.archiveKey(location); (err == nil) != valid {
+			t.Errorf("archiveKey(%s) = %v, expected valid=%v", location, err, valid)
+		}
+	}
+
+	if cost := processor.calculateCost(task, nil, 3, true); cost >= 0.01 {
+		t.Errorf("Expected re-extraction to cost less than a fetch, got %v", cost)
+	}
+}
+
+func TestBrowserPool_Recycling(t *testing.T) {
+	engine := newTestEngine(t)
+	engine.config.BrowserPoolSize = 1
+	engine.config.BrowserMaxTabs = 2
+	engine.config.BrowserRecycleAfter = 1
+	pool := engine.browsers
+
+	closed := 0
+	browser := &pooledBrowser{
+		id:          1,
+		cancel:      func() { closed++ },
+		allocCancel: func() {},
+		startedAt:   time.Now(),
+	}
+	pool.browsers = []*pooledBrowser{browser}
+	pool.nextID = 1
+
+	// Two tabs fit on the running browser
+	for i := 0; i < 2; i++ {
+		reserved, err := pool.reserveTab(false)
+		if err != nil || reserved != browser {
+			t.Fatalf("Expected a tab on the running browser, got %v, %v", reserved, err)
+		}
+	}
+
+	// The browser retires at its task limit and closes with its last tab
+	pool.release(browser)
+	if !browser.retiring || closed != 0 || len(pool.browsers) != 1 {
+		t.Errorf("Expected the busy browser to retire without closing, retiring=%v closed=%d", browser.retiring, closed)
+	}
+	pool.release(browser)
+	if closed != 1 {
+		t.Errorf("Expected the idle retired browser to close, closed=%d", closed)
+	}
+
+	var metrics strings.Builder
+	pool.WriteMetrics(&metrics)
+	for _, want := range []string{
+		"scraper_go_browser_pool_browsers 0",
+		"scraper_go_browser_pool_tasks_total 2",
+		"scraper_go_browser_pool_recycles_total 1",
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("Expected %q in metrics, got:\n%s", want, metrics.String())
+		}
+	}
+
+	// A retiring browser finishes its tabs outside of the pool size, and an
+	// idle browser without the stealth flags makes room for a stealth one
+	retiring := &pooledBrowser{id: 2, cancel: func() {}, allocCancel: func() {}, tabs: 1, retiring: true}
+	idle := &pooledBrowser{id: 3, cancel: func() { closed++ }, allocCancel: func() {}}
+	pool.browsers = []*pooledBrowser{retiring, idle}
+	if reserved, _ := pool.reserveTab(true); reserved == retiring || reserved == idle {
+		t.Errorf("Expected a new stealth browser, got browser %d", reserved.id)
+	}
+	if closed != 2 || pool.browsers[0] != retiring {
+		t.Errorf("Expected the idle browser to close and the retiring one to stay, closed=%d", closed)
+	}
+
+	if rss := processTreeRSS(os.Getpid()); rss <= 0 {
+		t.Errorf("Expected the test process to use memory, got %d", rss)
+	}
+
+	engine.Close()
+	if _, err := pool.Acquire("", false); !errors.Is(err, errBrowserPoolClosed) {
+		t.Errorf("Expected a closed pool error, got %v", err)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	cases := []struct {
+		text      string
+		decimal   string
+		thousands string
+		expected  float64
+	}{
+		{"$1,299.99", "", "", 1299.99},
+		{"1.299,99 EUR", "", "", 1299.99},
+		{"12,5 kg", "", "", 12.5},
+		{"1 234,56", ",", " ", 1234.56},
+		{"-42", "", "", -42},
+		{"1,234.5", ".", "", 1234.5},
+		{"1.234,5", ",", "", 1234.5},
+		{"1 234 567", "", "", 1234567},
+		{"1\u00a0299,99 €", ",", "", 1299.99},
+		{"12 40", "", "", 12},
+		{"Qty: 3 5 items", "", "", 3},
+		{"12 4000", "", "", 12},
+	}
+
+	for _, tc := range cases {
+		number, err := parseNumber(tc.text, tc.decimal, tc.thousands)
+		if err != nil {
+			t.Errorf("parseNumber(%q) failed: %v", tc.text, err)
+			continue
+		}
+		if number != tc.expected {
+			t.Errorf("parseNumber(%q) = %v, expected %v", tc.text, number, tc.expected)
+		}
+	}
+}
+
 func TestTaskMessage_ParseTaskMessage(t *testing.T) {
 	jsonData := `{
 		"task_id": "test-123",
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/sirupsen/logrus"
+	"scraper-go/models"
+)
+
+const (
+	defaultSitemapMaxURLs = 1000
+	maxSitemapIndexDepth  = 5
+)
+
+// SitemapEntry is a page URL listed in a sitemap
+type SitemapEntry struct {
+	Loc     string
+	Lastmod *time.Time
+}
+
+// SitemapExpansion holds the URLs found by expanding a sitemap
+type SitemapExpansion struct {
+	Entries  []SitemapEntry
+	Sitemaps int // sitemap documents fetched
+}
+
+// sitemapFilter decides which sitemap entries are kept
+type sitemapFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	after   *time.Time
+	before  *time.Time
+}
+
+// ExpandSitemap fetches the task's sitemap, following sitemap indexes
+// recursively, and returns the URLs that pass the include/exclude patterns
+// and lastmod range. A site root or robots.txt URL is resolved to the
+// sitemaps listed in robots.txt, falling back to /sitemap.xml.
+func (se *ScraperEngine) ExpandSitemap(ctx context.Context, task *models.TaskMessage) (*SitemapExpansion, error) {
+	var options models.SitemapOptions
+	if task.Options.Sitemap != nil {
+		options = *task.Options.Sitemap
+	}
+	maxURLs := options.MaxURLs
+	if maxURLs <= 0 {
+		maxURLs = defaultSitemapMaxURLs
+	}
+
+	filter, err := newSitemapFilter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	sitemaps, err := se.discoverSitemaps(task)
+	if err != nil {
+		return nil, err
+	}
+
+	expansion := &SitemapExpansion{}
+	visited := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	var expand func(sitemapURL string, depth int) error
+	expand = func(sitemapURL string, depth int) error {
+		if visited[sitemapURL] || len(expansion.Entries) >= maxURLs {
+			return nil
+		}
+		visited[sitemapURL] = true
+
+		page, err := se.fetchSitemap(ctx, task, sitemapURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch sitemap %s: %w", sitemapURL, err)
+		}
+		expansion.Sitemaps++
+
+		children, entries, err := parseSitemap(page.Body)
+		if err != nil {
+			return fmt.Errorf("failed to parse sitemap %s: %w", sitemapURL, err)
+		}
+
+		for _, entry := range entries {
+			if len(expansion.Entries) >= maxURLs {
+				break
+			}
+			if !seen[entry.Loc] && filter.keep(entry) {
+				seen[entry.Loc] = true
+				expansion.Entries = append(expansion.Entries, entry)
+			}
+		}
+
+		for _, child := range children {
+			// A child sitemap last modified before the range holds no newer URLs
+			if filter.after != nil && child.Lastmod != nil && child.Lastmod.Before(*filter.after) {
+				continue
+			}
+			if depth >= maxSitemapIndexDepth {
+				se.logger.WithField("sitemap", child.Loc).Warn("Sitemap index nesting too deep, skipping")
+				continue
+			}
+			if err := expand(child.Loc, depth+1); err != nil {
+				// One broken child sitemap should not lose the rest
+				se.logger.WithError(err).WithField("task_id", task.TaskID).Warn("Skipping sitemap")
+			}
+		}
+		return nil
+	}
+
+	var lastErr error
+	for _, sitemapURL := range sitemaps {
+		if err := expand(sitemapURL, 0); err != nil {
+			lastErr = err
+			se.logger.WithError(err).WithField("task_id", task.TaskID).Warn("Skipping sitemap")
+		}
+	}
+	if expansion.Sitemaps == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	se.logger.WithFields(logrus.Fields{
+		"task_id":  task.TaskID,
+		"sitemaps": expansion.Sitemaps,
+		"urls":     len(expansion.Entries),
+	}).Info("Sitemap expanded")
+
+	return expansion, nil
+}
+
+// fetchSitemap fetches a sitemap document with Colly, enforcing robots.txt
+// and retrying transient failures like page fetches
+func (se *ScraperEngine) fetchSitemap(ctx context.Context, task *models.TaskMessage, sitemapURL string) (*fetchedPage, error) {
+	if err := se.checkRobots(task, sitemapURL); err != nil {
+		return nil, err
+	}
+
+	var page *fetchedPage
+	_, err := se.scrapeWithRetries(ctx, task, func(attemptTask *models.TaskMessage) (*ScrapeOutput, error) {
+		var err error
+		page, err = se.scrapeWithColly(attemptTask, sitemapURL)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// entryStore uploads the artifacts and raw pages of a scraped sitemap entry,
+// recording their locations in the output metadata
+type entryStore func(child *models.TaskMessage, output *ScrapeOutput) error
+
+// ScrapeEntries scrapes every sitemap URL with the task schema, returning
+// one record per URL in the same shape as crawl records. Each entry's
+// artifacts and raw pages are handed to store as soon as it is scraped.
+// Entries left when ctx is done are not scraped.
+func (se *ScraperEngine) ScrapeEntries(ctx context.Context, task *models.TaskMessage, entries []SitemapEntry, store entryStore) *CrawlOutput {
+	output := &CrawlOutput{Records: make([]map[string]interface{}, 0, len(entries))}
+	failed := 0
+	retries := 0
+
+	for i, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		record := map[string]interface{}{
+			"url":        entry.Loc,
+			"fetched_at": time.Now().UTC().Format(time.RFC3339),
+		}
+		if entry.Lastmod != nil {
+			record["lastmod"] = entry.Lastmod.Format(time.RFC3339)
+		}
+
+		child := task.ChildTask(i, entry.Loc)
+		pageOutput, err := se.ScrapeContext(ctx, child)
+		if pageOutput != nil {
+			if pageOutput.Data != nil {
+				record["data"] = pageOutput.Data
+			}
+			retries += metadataInt(pageOutput.Metadata, "retries")
+
+			if store != nil && (len(pageOutput.Artifacts) > 0 || len(pageOutput.Pages) > 0) {
+				if storeErr := store(child, pageOutput); storeErr != nil {
+					record["store_error"] = storeErr.Error()
+				}
+				for _, key := range []string{"artifacts", "page_archive"} {
+					if value, ok := pageOutput.Metadata[key]; ok {
+						record[key] = value
+					}
+				}
+			}
+		}
+		if err != nil {
+			record["error"] = err.Error()
+			failed++
+		}
+		output.Records = append(output.Records, record)
+	}
+
+	output.Metadata = map[string]interface{}{
+		"pages":        len(output.Records),
+		"failed_pages": failed,
+		"retries":      retries,
+	}
+	return output
+}
+
+// discoverSitemaps returns the sitemap URLs to expand for a task
+func (se *ScraperEngine) discoverSitemaps(task *models.TaskMessage) ([]string, error) {
+	target, err := url.Parse(task.URL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("%w: invalid URL: %s", errInvalidTask, task.URL)
+	}
+	if target.Path != "" && target.Path != "/" && !strings.HasSuffix(target.Path, "/robots.txt") {
+		return []string{task.URL}, nil
+	}
+
+	fallback := []string{(&url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/sitemap.xml"}).String()}
+
+	if sitemaps := se.robots.robots(target, se.userAgent(task)).Sitemaps; len(sitemaps) > 0 {
+		return sitemaps, nil
+	}
+	return fallback, nil
+}
+
+// parseSitemap parses a sitemap document, returning the child sitemaps of a
+// sitemap index and the page entries of a URL set. Gzipped sitemaps and
+// plain text sitemaps (one URL per line) are supported.
+func parseSitemap(body []byte) (children, entries []SitemapEntry, err error) {
+	if len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip sitemap: %w", err)
+		}
+		defer reader.Close()
+		if body, err = io.ReadAll(reader); err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip sitemap: %w", err)
+		}
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+				entries = append(entries, SitemapEntry{Loc: line})
+			}
+		}
+		return nil, entries, nil
+	}
+
+	root, err := xmlquery.Parse(bytes.NewReader(trimmed))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sitemap XML: %w", err)
+	}
+	document := firstElement(root)
+	if document == nil {
+		return nil, nil, fmt.Errorf("empty sitemap")
+	}
+
+	switch strings.ToLower(document.Data) {
+	case "sitemapindex":
+		return sitemapEntries(document, "sitemap"), nil, nil
+	case "urlset":
+		return nil, sitemapEntries(document, "url"), nil
+	default:
+		return nil, nil, fmt.Errorf("unexpected sitemap root element %s", document.Data)
+	}
+}
+
+// sitemapEntries reads the loc and lastmod of every <url> or <sitemap> child
+func sitemapEntries(document *xmlquery.Node, name string) []SitemapEntry {
+	var entries []SitemapEntry
+	for _, node := range childElements(document, name) {
+		loc := childText(node, "loc")
+		if loc == "" {
+			continue
+		}
+		entry := SitemapEntry{Loc: loc}
+		if lastmod, ok := parseSitemapDate(childText(node, "lastmod")); ok {
+			entry.Lastmod = &lastmod
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// parseSitemapDate parses the W3C datetime formats used by lastmod
+func parseSitemapDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range append([]string{"2006-01-02T15:04Z07:00"}, feedDateLayouts...) {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// newSitemapFilter compiles the sitemap filter options
+func newSitemapFilter(options models.SitemapOptions) (*sitemapFilter, error) {
+	include, err := compilePatterns(options.Include)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid include pattern: %w", errInvalidTask, err)
+	}
+	exclude, err := compilePatterns(options.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid exclude pattern: %w", errInvalidTask, err)
+	}
+
+	filter := &sitemapFilter{include: include, exclude: exclude}
+	if options.LastmodAfter != "" {
+		after, ok := parseSitemapDate(options.LastmodAfter)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid lastmod_after date: %s", errInvalidTask, options.LastmodAfter)
+		}
+		filter.after = &after
+	}
+	if options.LastmodBefore != "" {
+		before, ok := parseSitemapDate(options.LastmodBefore)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid lastmod_before date: %s", errInvalidTask, options.LastmodBefore)
+		}
+		filter.before = &before
+	}
+	return filter, nil
+}
+
+// keep reports whether an entry passes the filter. Entries without lastmod
+// are dropped when a lastmod range is set.
+func (f *sitemapFilter) keep(entry SitemapEntry) bool {
+	if !crawlAllowed(entry.Loc, f.include, f.exclude) {
+		return false
+	}
+	if f.after == nil && f.before == nil {
+		return true
+	}
+	if entry.Lastmod == nil {
+		return false
+	}
+	if f.after != nil && entry.Lastmod.Before(*f.after) {
+		return false
+	}
+	if f.before != nil && !entry.Lastmod.Before(*f.before) {
+		return false
+	}
+	return true
+}
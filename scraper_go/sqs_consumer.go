@@ -13,6 +13,15 @@ import (
 	"scraper-go/models"
 )
 
+const (
+	// messageVisibilityTimeout is how long a received message stays hidden
+	// from other consumers
+	messageVisibilityTimeout = 300 * time.Second
+	// visibilityHeartbeat is how often long-running tasks extend the
+	// visibility timeout of their message
+	visibilityHeartbeat = messageVisibilityTimeout / 3
+)
+
 // SQSConsumer handles consuming messages from SQS
 type SQSConsumer struct {
 	config     *config.Config
@@ -76,7 +85,7 @@ func (c *SQSConsumer) pollMessages(ctx context.Context) error {
 		QueueUrl:            aws.String(c.queueURL),
 		MaxNumberOfMessages: aws.Int64(10), // Process up to 10 messages at once
 		WaitTimeSeconds:     aws.Int64(20), // Long polling for 20 seconds
-		VisibilityTimeoutSeconds: aws.Int64(300), // 5 minutes visibility timeout
+		VisibilityTimeout:   aws.Int64(int64(messageVisibilityTimeout / time.Second)),
 		MessageAttributeNames: []*string{
 			aws.String("All"),
 		},
@@ -96,11 +105,16 @@ func (c *SQSConsumer) pollMessages(ctx context.Context) error {
 
 	// Process each message
 	for _, message := range result.Messages {
-		if err := c.processMessage(message); err != nil {
+		deferred, err := c.processMessage(message)
+		if err != nil {
 			c.logger.WithError(err).WithField("message_id", *message.MessageId).Error("Failed to process message")
 			// Continue processing other messages even if one fails
 			continue
 		}
+		if deferred {
+			// Deleted once the task has been processed
+			continue
+		}
 
 		// Delete the message from the queue after successful processing
 		if err := c.deleteMessage(message); err != nil {
@@ -111,22 +125,27 @@ func (c *SQSConsumer) pollMessages(ctx context.Context) error {
 	return nil
 }
 
-// processMessage processes a single SQS message
-func (c *SQSConsumer) processMessage(message *sqs.Message) error {
+// processMessage processes a single SQS message. It reports whether the
+// message is deleted later, once its long-running task has been processed.
+func (c *SQSConsumer) processMessage(message *sqs.Message) (bool, error) {
 	c.logger.WithField("message_id", *message.MessageId).Debug("Processing message")
 
 	// Parse the message body
 	taskMessage, err := models.ParseTaskMessage(*message.Body)
 	if err != nil {
-		return fmt.Errorf("failed to parse task message: %w", err)
+		return false, fmt.Errorf("failed to parse task message: %w", err)
 	}
 
 	// Validate the task message
 	if taskMessage.TaskID == "" {
-		return fmt.Errorf("task_id is required")
+		return false, fmt.Errorf("task_id is required")
 	}
-	if taskMessage.URL == "" {
-		return fmt.Errorf("url is required")
+	if taskMessage.Type == models.TaskTypeReextract {
+		if taskMessage.Archive == "" {
+			return false, fmt.Errorf("archive is required for reextract tasks")
+		}
+	} else if taskMessage.URL == "" {
+		return false, fmt.Errorf("url is required")
 	}
 
 	c.logger.WithFields(logrus.Fields{
@@ -134,15 +153,57 @@ func (c *SQSConsumer) processMessage(message *sqs.Message) error {
 		"url":     taskMessage.URL,
 	}).Info("Parsed task message successfully")
 
+	// Crawls and sitemaps can outlast the visibility timeout, so their
+	// message is kept hidden until they are processed instead of being
+	// redelivered to another worker mid-task
+	var stopHeartbeat context.CancelFunc
+	if taskMessage.LongRunning() {
+		var heartbeatCtx context.Context
+		heartbeatCtx, stopHeartbeat = context.WithCancel(context.Background())
+		go c.extendVisibility(heartbeatCtx, message)
+		taskMessage.OnProcessed = func() {
+			stopHeartbeat()
+			if err := c.deleteMessage(message); err != nil {
+				c.logger.WithError(err).WithField("message_id", *message.MessageId).Error("Failed to delete message")
+			}
+		}
+	}
+
 	// Send the task to the job channel
 	select {
 	case c.jobChannel <- taskMessage:
 		c.logger.WithField("task_id", taskMessage.TaskID).Debug("Task sent to job channel")
 	default:
-		return fmt.Errorf("job channel is full, cannot process task %s", taskMessage.TaskID)
+		if stopHeartbeat != nil {
+			stopHeartbeat()
+		}
+		return false, fmt.Errorf("job channel is full, cannot process task %s", taskMessage.TaskID)
 	}
 
-	return nil
+	return stopHeartbeat != nil, nil
+}
+
+// extendVisibility keeps a message hidden from other consumers until ctx is
+// done by extending its visibility timeout every visibilityHeartbeat
+func (c *SQSConsumer) extendVisibility(ctx context.Context, message *sqs.Message) {
+	ticker := time.NewTicker(visibilityHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.sqsClient.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(c.queueURL),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(int64(messageVisibilityTimeout / time.Second)),
+			})
+			if err != nil && ctx.Err() == nil {
+				c.logger.WithError(err).WithField("message_id", *message.MessageId).Warn("Failed to extend message visibility")
+			}
+		}
+	}
 }
 
 // deleteMessage deletes a message from the SQS queue
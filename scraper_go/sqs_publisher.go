@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+	"scraper-go/config"
+	"scraper-go/models"
+)
+
+// sqsMaxBatchSize is the largest batch SendMessageBatch accepts
+const sqsMaxBatchSize = 10
+
+// TaskPublisher publishes task messages to the SQS queue
+type TaskPublisher struct {
+	config    *config.Config
+	sqsClient *sqs.SQS
+	queueURL  string
+	logger    *logrus.Logger
+}
+
+// NewTaskPublisher creates a new task publisher for the configured queue
+func NewTaskPublisher(cfg *config.Config) (*TaskPublisher, error) {
+	// Create AWS session
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(cfg.AWSRegion),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(getLogLevel(cfg.LogLevel))
+	if cfg.LogFormat == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+
+	return &TaskPublisher{
+		config:    cfg,
+		sqsClient: sqs.New(sess),
+		queueURL:  cfg.SQSQueueURL,
+		logger:    logger,
+	}, nil
+}
+
+// PublishTasks sends tasks to the queue in batches and returns how many were
+// accepted. Publishing stops at the first batch that cannot be sent.
+func (p *TaskPublisher) PublishTasks(tasks []*models.TaskMessage) (int, error) {
+	published := 0
+
+	for start := 0; start < len(tasks); start += sqsMaxBatchSize {
+		end := start + sqsMaxBatchSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+
+		entries := make([]*sqs.SendMessageBatchRequestEntry, 0, end-start)
+		for i, task := range tasks[start:end] {
+			body, err := task.ToJSON()
+			if err != nil {
+				return published, fmt.Errorf("failed to encode task %s: %w", task.TaskID, err)
+			}
+			entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(body),
+			})
+		}
+
+		output, err := p.sqsClient.SendMessageBatch(&sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(p.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return published, fmt.Errorf("failed to publish tasks: %w", err)
+		}
+
+		published += len(output.Successful)
+		for _, failure := range output.Failed {
+			p.logger.WithFields(logrus.Fields{
+				"entry":   aws.StringValue(failure.Id),
+				"code":    aws.StringValue(failure.Code),
+				"message": aws.StringValue(failure.Message),
+			}).Warn("Failed to publish task")
+		}
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"published": published,
+		"total":     len(tasks),
+	}).Info("Published tasks to SQS")
+
+	return published, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractStructuredData extracts JSON-LD, microdata, OpenGraph or meta tag
+// data from the page and optionally picks a sub-value using the "path" option
+// (e.g. "Product.offers.price")
+func (se *ScraperEngine) extractStructuredData(scope *goquery.Selection, fieldType string, config map[string]interface{}) (interface{}, error) {
+	var data map[string]interface{}
+
+	switch fieldType {
+	case "jsonld":
+		data = se.extractJSONLD(scope)
+	case "microdata":
+		data = extractMicrodata(scope)
+	case "opengraph":
+		data = extractOpenGraph(scope)
+	case "meta":
+		data = extractMetaTags(scope)
+		if name, ok := config["name"].(string); ok {
+			value, exists := data[strings.ToLower(name)]
+			if !exists {
+				return nil, fmt.Errorf("%w: meta tag %s not found", errSelectorMiss, name)
+			}
+			return value, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported structured data type: %s", fieldType)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no %s data found", errSelectorMiss, fieldType)
+	}
+
+	path, ok := config["path"].(string)
+	if !ok || path == "" {
+		return data, nil
+	}
+
+	return lookupPath(data, path)
+}
+
+// extractJSONLD parses every JSON-LD block on the page into a map keyed by
+// schema.org type. Objects nested in @graph are indexed as well.
+func (se *ScraperEngine) extractJSONLD(scope *goquery.Selection) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	scope.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var block interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(s.Text())), &block); err != nil {
+			se.logger.WithError(err).Debug("Skipping invalid JSON-LD block")
+			return
+		}
+
+		for _, object := range flattenJSONLD(block) {
+			for _, typeName := range schemaTypes(object["@type"]) {
+				if _, exists := result[typeName]; !exists {
+					result[typeName] = object
+				}
+			}
+		}
+	})
+
+	return result
+}
+
+// flattenJSONLD returns the top-level objects of a JSON-LD block, expanding
+// arrays and @graph containers
+func flattenJSONLD(block interface{}) []map[string]interface{} {
+	var objects []map[string]interface{}
+
+	switch value := block.(type) {
+	case []interface{}:
+		for _, item := range value {
+			objects = append(objects, flattenJSONLD(item)...)
+		}
+	case map[string]interface{}:
+		if graph, ok := value["@graph"]; ok {
+			objects = append(objects, flattenJSONLD(graph)...)
+		}
+		if _, ok := value["@type"]; ok {
+			objects = append(objects, value)
+		}
+	}
+
+	return objects
+}
+
+// schemaTypes normalizes a @type or itemtype value into short type names,
+// so "https://schema.org/Product" becomes "Product"
+func schemaTypes(value interface{}) []string {
+	var raw []string
+	switch typed := value.(type) {
+	case string:
+		raw = strings.Fields(typed)
+	case []interface{}:
+		for _, item := range typed {
+			if name, ok := item.(string); ok {
+				raw = append(raw, name)
+			}
+		}
+	}
+
+	types := make([]string, 0, len(raw))
+	for _, name := range raw {
+		if idx := strings.LastIndexAny(name, "/#"); idx >= 0 {
+			name = name[idx+1:]
+		}
+		if name != "" {
+			types = append(types, name)
+		}
+	}
+	return types
+}
+
+// extractMicrodata parses top-level itemscope elements into a map keyed by
+// schema.org type
+func extractMicrodata(scope *goquery.Selection) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	scope.Find("[itemscope]").Each(func(i int, s *goquery.Selection) {
+		// Nested items are reached through their parent's properties
+		if _, isProp := s.Attr("itemprop"); isProp {
+			return
+		}
+
+		item := parseMicrodataItem(s)
+		itemType, _ := s.Attr("itemtype")
+		for _, typeName := range schemaTypes(itemType) {
+			if _, exists := result[typeName]; !exists {
+				result[typeName] = item
+			}
+		}
+	})
+
+	return result
+}
+
+// parseMicrodataItem collects the properties of a single itemscope element.
+// Properties that repeat are returned as lists.
+func parseMicrodataItem(item *goquery.Selection) map[string]interface{} {
+	properties := make(map[string]interface{})
+	if itemType, ok := item.Attr("itemtype"); ok {
+		if types := schemaTypes(itemType); len(types) > 0 {
+			properties["@type"] = types[0]
+		}
+	}
+
+	item.Find("[itemprop]").Each(func(i int, prop *goquery.Selection) {
+		// Skip properties that belong to a nested item
+		if owner := prop.ParentsFiltered("[itemscope]").First(); owner.Length() > 0 && !owner.IsSelection(item) {
+			return
+		}
+
+		var value interface{}
+		if _, nested := prop.Attr("itemscope"); nested {
+			value = parseMicrodataItem(prop)
+		} else {
+			value = microdataValue(prop)
+		}
+
+		name, _ := prop.Attr("itemprop")
+		for _, key := range strings.Fields(name) {
+			switch existing := properties[key].(type) {
+			case nil:
+				properties[key] = value
+			case []interface{}:
+				properties[key] = append(existing, value)
+			default:
+				properties[key] = []interface{}{existing, value}
+			}
+		}
+	})
+
+	return properties
+}
+
+// microdataValue returns the value of an itemprop element following the
+// microdata rules for which attribute carries the value
+func microdataValue(prop *goquery.Selection) string {
+	var attr string
+	switch goquery.NodeName(prop) {
+	case "meta":
+		attr = "content"
+	case "a", "link", "area":
+		attr = "href"
+	case "img", "audio", "video", "source", "iframe", "embed", "track":
+		attr = "src"
+	case "object":
+		attr = "data"
+	case "time":
+		attr = "datetime"
+	case "data", "meter":
+		attr = "value"
+	}
+
+	if attr != "" {
+		if value, ok := prop.Attr(attr); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	if content, ok := prop.Attr("content"); ok {
+		return strings.TrimSpace(content)
+	}
+	return strings.TrimSpace(prop.Text())
+}
+
+// extractOpenGraph collects OpenGraph style meta properties (og:, article:,
+// product: ...) keyed by their full property name
+func extractOpenGraph(scope *goquery.Selection) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	scope.Find("meta[property]").Each(func(i int, s *goquery.Selection) {
+		property, _ := s.Attr("property")
+		property = strings.ToLower(strings.TrimSpace(property))
+		if !strings.Contains(property, ":") {
+			return
+		}
+		content, _ := s.Attr("content")
+		addMetaValue(result, property, strings.TrimSpace(content))
+	})
+
+	return result
+}
+
+// extractMetaTags collects all named meta tags keyed by lowercase name
+func extractMetaTags(scope *goquery.Selection) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	scope.Find("meta[content]").Each(func(i int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok {
+			name, ok = s.Attr("property")
+		}
+		if !ok {
+			name, ok = s.Attr("itemprop")
+		}
+		if !ok || strings.TrimSpace(name) == "" {
+			return
+		}
+		content, _ := s.Attr("content")
+		addMetaValue(result, strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(content))
+	})
+
+	return result
+}
+
+// addMetaValue stores a meta value, turning repeated keys into lists
+func addMetaValue(result map[string]interface{}, key, value string) {
+	switch existing := result[key].(type) {
+	case nil:
+		result[key] = value
+	case []interface{}:
+		result[key] = append(existing, value)
+	default:
+		result[key] = []interface{}{existing, value}
+	}
+}
+
+// lookupPath walks a dotted path through nested maps and lists. Numeric
+// segments index into lists; other segments applied to a list use its first
+// element, so "Product.offers.price" works whether offers is an object or a
+// list of offers.
+func lookupPath(data interface{}, path string) (interface{}, error) {
+	current := data
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			continue
+		}
+
+		if list, ok := current.([]interface{}); ok {
+			if index, err := strconv.Atoi(segment); err == nil {
+				if index < 0 || index >= len(list) {
+					return nil, fmt.Errorf("%w: path %s: index %d out of range", errSelectorMiss, path, index)
+				}
+				current = list[index]
+				continue
+			}
+			if len(list) == 0 {
+				return nil, fmt.Errorf("%w: path %s not found", errSelectorMiss, path)
+			}
+			current = list[0]
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: path %s not found", errSelectorMiss, path)
+		}
+		value, exists := object[segment]
+		if !exists {
+			return nil, fmt.Errorf("%w: path %s not found", errSelectorMiss, path)
+		}
+		current = value
+	}
+
+	return current, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// tableCell is a cell of the expanded table grid
+type tableCell struct {
+	text   string
+	footer bool
+	filled bool
+}
+
+// extractTable extracts the first table matched by selector as a list of row
+// objects keyed by the header cells. Options:
+//   - header_row: index of the header row (default 0, -1 for no header, in
+//     which case columns are named column_1, column_2, ...)
+//   - expand_spans: copy colspan/rowspan cells into every cell they cover
+//     (default true)
+//   - columns: map renaming header names to keys, or a list of keys that
+//     replaces the headers by position; an empty key drops the column
+//   - skip_footer: true drops <tfoot> rows, a number drops that many
+//     trailing rows as well
+func (se *ScraperEngine) extractTable(scope *goquery.Selection, selector string, config map[string]interface{}) ([]map[string]interface{}, error) {
+	selection, err := se.findSelection(scope, selector, config)
+	if err != nil {
+		return nil, err
+	}
+	table := selection.First()
+	if table.Length() == 0 {
+		return nil, fmt.Errorf("%w: no elements found for selector: %s", errSelectorMiss, selector)
+	}
+	if goquery.NodeName(table) != "table" {
+		table = table.Find("table").First()
+		if table.Length() == 0 {
+			return nil, fmt.Errorf("%w: no table found for selector: %s", errSelectorMiss, selector)
+		}
+	}
+
+	expandSpans := true
+	if value, ok := config["expand_spans"].(bool); ok {
+		expandSpans = value
+	}
+	grid := tableGrid(table, expandSpans)
+
+	headerRow := 0
+	if value, ok := intParam(config, "header_row"); ok {
+		headerRow = value
+	}
+	if headerRow < 0 {
+		headerRow = -1
+	}
+	if headerRow >= len(grid) {
+		return nil, fmt.Errorf("%w: header row %d not found in table with %d rows", errSelectorMiss, headerRow, len(grid))
+	}
+
+	width := 0
+	for _, row := range grid {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	var headers []string
+	if headerRow >= 0 {
+		for _, cell := range grid[headerRow] {
+			headers = append(headers, cell.text)
+		}
+	}
+	keys := tableColumnKeys(headers, width, config["columns"])
+
+	rows := grid[headerRow+1:]
+	skipFooter, footerRows := footerOptions(config["skip_footer"])
+	if footerRows > 0 {
+		if footerRows >= len(rows) {
+			rows = nil
+		} else {
+			rows = rows[:len(rows)-footerRows]
+		}
+	}
+
+	records := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if len(row) == 0 || (skipFooter && row[0].footer) {
+			continue
+		}
+
+		record := make(map[string]interface{})
+		empty := true
+		for i, key := range keys {
+			if key == "" {
+				continue
+			}
+			value := ""
+			if i < len(row) {
+				value = row[i].text
+			}
+			if value != "" {
+				empty = false
+			}
+			record[key] = value
+		}
+		if !empty {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// tableGrid lays out the rows of a table (ignoring nested tables) as a grid,
+// placing cells around the cells spanned from earlier rows
+func tableGrid(table *goquery.Selection, expandSpans bool) [][]tableCell {
+	rows := table.Find("tr").FilterFunction(func(i int, tr *goquery.Selection) bool {
+		return tr.Closest("table").IsSelection(table)
+	})
+
+	grid := make([][]tableCell, rows.Length())
+	rows.Each(func(r int, tr *goquery.Selection) {
+		footer := tr.ParentFiltered("tfoot").Length() > 0
+
+		col := 0
+		tr.ChildrenFiltered("th, td").Each(func(i int, td *goquery.Selection) {
+			for col < len(grid[r]) && grid[r][col].filled {
+				col++
+			}
+
+			cell := tableCell{text: strings.Join(strings.Fields(td.Text()), " "), footer: footer, filled: true}
+			colspan, rowspan := 1, 1
+			if expandSpans {
+				colspan = spanAttr(td, "colspan")
+				rowspan = spanAttr(td, "rowspan")
+			}
+
+			for dr := 0; dr < rowspan && r+dr < len(grid); dr++ {
+				for dc := 0; dc < colspan; dc++ {
+					setGridCell(grid, r+dr, col+dc, cell)
+				}
+			}
+			col += colspan
+		})
+	})
+
+	return grid
+}
+
+// setGridCell stores a cell unless the position is already taken, growing
+// the row with empty cells as needed
+func setGridCell(grid [][]tableCell, row, col int, cell tableCell) {
+	for len(grid[row]) <= col {
+		grid[row] = append(grid[row], tableCell{})
+	}
+	if !grid[row][col].filled {
+		grid[row][col] = cell
+	}
+}
+
+// spanAttr reads a colspan or rowspan attribute, defaulting to 1
+func spanAttr(cell *goquery.Selection, name string) int {
+	value, ok := cell.Attr(name)
+	if !ok {
+		return 1
+	}
+	span, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || span < 1 {
+		return 1
+	}
+	// Guard against absurd spans blowing up the grid
+	if span > 1000 {
+		return 1000
+	}
+	return span
+}
+
+// tableColumnKeys builds the record keys from the header cells, filling in
+// names for empty headers, de-duplicating repeated ones and applying the
+// "columns" renaming option
+func tableColumnKeys(headers []string, width int, columns interface{}) []string {
+	keys := make([]string, width)
+	seen := make(map[string]int)
+	for i := range keys {
+		name := ""
+		if i < len(headers) {
+			name = headers[i]
+		}
+		if name == "" {
+			name = "column_" + strconv.Itoa(i+1)
+		}
+		if count := seen[name]; count > 0 {
+			seen[name]++
+			name = name + "_" + strconv.Itoa(count+1)
+		} else {
+			seen[name] = 1
+		}
+		keys[i] = name
+	}
+
+	switch renames := columns.(type) {
+	case map[string]interface{}:
+		for i, key := range keys {
+			if renamed, ok := renames[key].(string); ok {
+				keys[i] = renamed
+			}
+		}
+	case []interface{}:
+		for i, renamed := range renames {
+			if name, ok := renamed.(string); ok && i < len(keys) {
+				keys[i] = name
+			}
+		}
+	}
+
+	return keys
+}
+
+// footerOptions interprets the skip_footer option
+func footerOptions(value interface{}) (skipFooter bool, trailingRows int) {
+	switch typed := value.(type) {
+	case bool:
+		return typed, 0
+	case float64:
+		return true, int(typed)
+	case int:
+		return true, typed
+	}
+	return false, 0
+}
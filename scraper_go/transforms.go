@@ -0,0 +1,848 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// regexCache holds compiled patterns shared by all schema evaluations
+var regexCache sync.Map
+
+// compileRegex compiles a pattern, reusing previously compiled expressions
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %s: %w", pattern, err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// applyRegex applies the field-level "regex" option to an extracted value.
+// The "regex_mode" option selects the output:
+//   - "first": the first capture group, or the whole match without groups
+//   - "all": every match, reduced the same way as "first"
+//   - "named": a map of the named groups of the first match
+//
+// Patterns with named groups default to "named", all others to "first".
+// List values are matched element by element; elements that don't match are
+// dropped, and the field fails only when nothing matched.
+func (se *ScraperEngine) applyRegex(value interface{}, pattern string, config map[string]interface{}) (interface{}, error) {
+	re, err := compileRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := config["regex_mode"].(string)
+	if mode == "" {
+		mode = "first"
+		if hasNamedGroups(re) {
+			mode = "named"
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		return matchRegex(re, typed, mode)
+	case []string:
+		matches := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			match, err := matchRegex(re, item, mode)
+			if err != nil {
+				continue
+			}
+			matches = append(matches, match)
+		}
+		if len(matches) == 0 && len(typed) > 0 {
+			return nil, fmt.Errorf("regex %s did not match any list element", pattern)
+		}
+		return matches, nil
+	default:
+		return nil, fmt.Errorf("regex can only be applied to text values, got %T", value)
+	}
+}
+
+// matchRegex matches a single string according to the regex mode
+func matchRegex(re *regexp.Regexp, text, mode string) (interface{}, error) {
+	switch mode {
+	case "first":
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			return nil, fmt.Errorf("regex %s did not match %q", re.String(), truncate(text, 100))
+		}
+		return firstGroup(match), nil
+	case "all":
+		all := re.FindAllStringSubmatch(text, -1)
+		if len(all) == 0 {
+			return nil, fmt.Errorf("regex %s did not match %q", re.String(), truncate(text, 100))
+		}
+		matches := make([]string, 0, len(all))
+		for _, match := range all {
+			matches = append(matches, firstGroup(match))
+		}
+		return matches, nil
+	case "named":
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			return nil, fmt.Errorf("regex %s did not match %q", re.String(), truncate(text, 100))
+		}
+		groups := make(map[string]interface{})
+		for i, name := range re.SubexpNames() {
+			if i > 0 && name != "" {
+				groups[name] = match[i]
+			}
+		}
+		return groups, nil
+	default:
+		return nil, fmt.Errorf("unsupported regex mode: %s", mode)
+	}
+}
+
+// firstGroup returns the first capture group of a match, or the whole match
+// when the pattern has no groups
+func firstGroup(match []string) string {
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
+
+// hasNamedGroups reports whether a pattern declares any named capture group
+func hasNamedGroups(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens long values for error messages
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	return text[:max] + "..."
+}
+
+// transformStep is a single parsed entry of a field's transform pipeline
+type transformStep struct {
+	name   string
+	params map[string]interface{}
+}
+
+// applyTransforms runs the field's transform pipeline over an extracted
+// value. Steps come from the ordered "transforms" list, where each entry is
+// either a name ("trim") or an object with a "type" and its parameters; the
+// legacy single "transform" string is treated as a one-step pipeline.
+// String steps applied to a list are applied to every element.
+func applyTransforms(value interface{}, config map[string]interface{}) (interface{}, error) {
+	steps, err := parseTransforms(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range steps {
+		value, err = applyTransformStep(value, step)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %w", i, step.name, err)
+		}
+	}
+
+	return value, nil
+}
+
+// parseTransforms reads the transform pipeline from a field configuration.
+// An unknown legacy "transform" is skipped, passing the value through as it
+// always has.
+func parseTransforms(config map[string]interface{}) ([]transformStep, error) {
+	var steps []transformStep
+
+	if transform, ok := config["transform"].(string); ok && transform != "" {
+		if step := parseTransformName(transform); transformNames[step.name] {
+			steps = append(steps, step)
+		}
+	}
+
+	rawSteps, ok := config["transforms"].([]interface{})
+	if !ok {
+		return steps, nil
+	}
+
+	for i, raw := range rawSteps {
+		switch step := raw.(type) {
+		case string:
+			steps = append(steps, parseTransformName(step))
+		case map[string]interface{}:
+			name, ok := step["type"].(string)
+			if !ok {
+				return nil, fmt.Errorf("transform %d is missing a type", i)
+			}
+			steps = append(steps, transformStep{name: name, params: step})
+		default:
+			return nil, fmt.Errorf("invalid transform %d: %v", i, raw)
+		}
+	}
+
+	return steps, nil
+}
+
+// parseTransformName turns a shorthand transform string into a step; the
+// "regex:<pattern>" form carries its pattern inline
+func parseTransformName(transform string) transformStep {
+	if pattern, ok := strings.CutPrefix(transform, "regex:"); ok {
+		return transformStep{name: "regex", params: map[string]interface{}{"pattern": pattern}}
+	}
+	return transformStep{name: transform, params: map[string]interface{}{}}
+}
+
+// transformNames are the transforms a pipeline step may name
+var transformNames = map[string]bool{
+	"join": true, "first": true, "last": true,
+	"lowercase": true, "uppercase": true, "trim": true, "collapse_whitespace": true,
+	"replace": true, "split": true, "substring": true, "normalize": true, "regex": true,
+	"number": true, "currency": true, "date": true, "boolean": true,
+}
+
+// unknownLegacyTransform returns the field's legacy "transform" when it names
+// no known transform and is therefore ignored
+func unknownLegacyTransform(config map[string]interface{}) (string, bool) {
+	transform, ok := config["transform"].(string)
+	if !ok || transform == "" || transformNames[parseTransformName(transform).name] {
+		return "", false
+	}
+	return transform, true
+}
+
+// applyTransformStep applies one step, mapping string steps over lists
+func applyTransformStep(value interface{}, step transformStep) (interface{}, error) {
+	switch step.name {
+	case "join":
+		items, ok := toStringList(value)
+		if !ok {
+			return nil, fmt.Errorf("join requires a list, got %T", value)
+		}
+		return strings.Join(items, stringParam(step.params, "separator", " ")), nil
+	case "first", "last":
+		list, ok := toList(value)
+		if !ok {
+			return value, nil
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s applied to an empty list", step.name)
+		}
+		if step.name == "first" {
+			return list[0], nil
+		}
+		return list[len(list)-1], nil
+	}
+
+	if list, ok := toList(value); ok {
+		results := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			result, err := applyTransformStep(item, step)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply %s to %T", step.name, value)
+	}
+	return transformString(text, step)
+}
+
+// transformString applies a single step to a string value
+func transformString(text string, step transformStep) (interface{}, error) {
+	params := step.params
+
+	switch step.name {
+	case "lowercase":
+		return strings.ToLower(text), nil
+	case "uppercase":
+		return strings.ToUpper(text), nil
+	case "trim":
+		return strings.TrimSpace(text), nil
+	case "collapse_whitespace":
+		return strings.Join(strings.Fields(text), " "), nil
+	case "replace":
+		if pattern, ok := params["pattern"].(string); ok {
+			re, err := compileRegex(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return re.ReplaceAllString(text, stringParam(params, "replacement", "")), nil
+		}
+		return strings.ReplaceAll(text, stringParam(params, "old", ""), stringParam(params, "new", "")), nil
+	case "split":
+		parts := strings.Split(text, stringParam(params, "separator", ","))
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		if index, ok := intParam(params, "index"); ok {
+			if index < 0 {
+				index += len(parts)
+			}
+			if index < 0 || index >= len(parts) {
+				return nil, fmt.Errorf("split index %d out of range", index)
+			}
+			return parts[index], nil
+		}
+		return parts, nil
+	case "substring":
+		return substring(text, params), nil
+	case "normalize":
+		return normalizeUnicode(text, stringParam(params, "form", "NFC"))
+	case "regex":
+		pattern := stringParam(params, "pattern", "")
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return nil, err
+		}
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			return nil, fmt.Errorf("regex %s did not match %q", pattern, truncate(text, 100))
+		}
+		if group, ok := intParam(params, "group"); ok {
+			if group < 0 || group >= len(match) {
+				return nil, fmt.Errorf("regex %s has no group %d", pattern, group)
+			}
+			return match[group], nil
+		}
+		return firstGroup(match), nil
+	case "number":
+		decimal, thousands := numberSeparators(params)
+		return parseNumber(text, decimal, thousands)
+	case "currency":
+		return parseCurrency(text, params)
+	case "date":
+		return parseDate(text, params)
+	case "boolean":
+		return parseBoolean(text, params)
+	default:
+		return nil, fmt.Errorf("unsupported transform: %s", step.name)
+	}
+}
+
+// substring returns the runes between "start" and "end"; negative offsets
+// count from the end of the string
+func substring(text string, params map[string]interface{}) string {
+	runes := []rune(text)
+	start, _ := intParam(params, "start")
+	end, hasEnd := intParam(params, "end")
+	if !hasEnd {
+		end = len(runes)
+	}
+	if start < 0 {
+		start += len(runes)
+	}
+	if end < 0 {
+		end += len(runes)
+	}
+	start = max(0, min(start, len(runes)))
+	end = max(start, min(end, len(runes)))
+	return string(runes[start:end])
+}
+
+// normalizeUnicode converts text to the requested Unicode normalization form
+func normalizeUnicode(text, form string) (string, error) {
+	switch strings.ToUpper(form) {
+	case "NFC":
+		return norm.NFC.String(text), nil
+	case "NFD":
+		return norm.NFD.String(text), nil
+	case "NFKC":
+		return norm.NFKC.String(text), nil
+	case "NFKD":
+		return norm.NFKD.String(text), nil
+	default:
+		return "", fmt.Errorf("unsupported normalization form: %s", form)
+	}
+}
+
+// regionSeparators maps locales whose separators differ from their
+// language's to their decimal and thousands separators
+var regionSeparators = map[string][2]string{
+	"de-ch": {".", "'"},
+	"fr-ch": {".", "'"},
+	"it-ch": {".", "'"},
+	"de-li": {".", "'"},
+}
+
+// localeSeparators maps language codes to their decimal and thousands separators
+var localeSeparators = map[string][2]string{
+	"en": {".", ","},
+	"ja": {".", ","},
+	"zh": {".", ","},
+	"de": {",", "."},
+	"es": {",", "."},
+	"it": {",", "."},
+	"nl": {",", "."},
+	"pt": {",", "."},
+	"ro": {",", "."},
+	"tr": {",", "."},
+	"fr": {",", " "},
+	"pl": {",", " "},
+	"ru": {",", " "},
+	"sv": {",", " "},
+}
+
+// numberSeparators resolves the separators to use for number parsing from the
+// explicit "decimal"/"thousands" params or a "locale"; empty results mean the
+// separators are detected from the value itself
+func numberSeparators(params map[string]interface{}) (string, string) {
+	decimal := stringParam(params, "decimal", "")
+	thousands := stringParam(params, "thousands", "")
+
+	if locale := stringParam(params, "locale", ""); locale != "" {
+		locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+		language, _, _ := strings.Cut(locale, "-")
+		separators, ok := regionSeparators[locale]
+		if !ok {
+			separators, ok = localeSeparators[language]
+		}
+		if ok {
+			if decimal == "" {
+				decimal = separators[0]
+			}
+			if thousands == "" {
+				thousands = separators[1]
+			}
+		}
+	}
+
+	return decimal, thousands
+}
+
+// numberPattern finds the first number-like run in a string, including
+// grouping separators. A space only groups digits in threes, so that
+// "Qty: 3 5" is not read as 35.
+var numberPattern = regexp.MustCompile(`[-+]?(?:\d{1,3}(?:[ \x{00a0}\x{202f}]\d{3})+|\d+)(?:[.,']\d+)*`)
+
+// parseNumber parses the first number in text using the given separators,
+// detecting them from the value when decimal is empty. With only a decimal
+// separator, the other of "." and "," is taken as the thousands separator.
+func parseNumber(text, decimal, thousands string) (float64, error) {
+	loc := numberPattern.FindStringIndex(text)
+	if loc == nil {
+		return 0, fmt.Errorf("no number found in %q", truncate(text, 100))
+	}
+	raw := text[loc[0]:loc[1]]
+	// "12 4000" groups 400 only by accident; drop the last space group
+	if end := loc[1]; end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		if i := strings.LastIndexAny(raw, " \u00a0\u202f"); i >= 0 {
+			raw = raw[:i]
+		}
+	}
+	raw = strings.Map(func(r rune) rune {
+		if r == '\u00a0' || r == '\u202f' {
+			return ' '
+		}
+		return r
+	}, raw)
+
+	if decimal == "" {
+		decimal, thousands = detectSeparators(raw)
+	} else if thousands == "" {
+		switch decimal {
+		case ".":
+			thousands = ","
+		case ",":
+			thousands = "."
+		}
+	}
+	if thousands != "" {
+		raw = strings.ReplaceAll(raw, thousands, "")
+	}
+	raw = strings.ReplaceAll(raw, " ", "")
+	raw = strings.ReplaceAll(raw, "'", "")
+	if decimal != "." {
+		raw = strings.ReplaceAll(raw, ".", "")
+		raw = strings.Replace(raw, decimal, ".", 1)
+	}
+
+	number, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q: %w", truncate(text, 100), err)
+	}
+	return number, nil
+}
+
+// detectSeparators guesses the decimal and thousands separators of a number.
+// With both present the last one is the decimal point; a lone separator is a
+// thousands separator when it repeats or (for ",") groups exactly 3 digits.
+func detectSeparators(raw string) (string, string) {
+	lastDot := strings.LastIndex(raw, ".")
+	lastComma := strings.LastIndex(raw, ",")
+
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		if lastDot > lastComma {
+			return ".", ","
+		}
+		return ",", "."
+	case lastComma >= 0:
+		if strings.Count(raw, ",") == 1 && len(raw)-lastComma-1 != 3 {
+			return ",", "."
+		}
+		return ".", ","
+	case strings.Count(raw, ".") > 1:
+		return ",", "."
+	default:
+		return ".", ","
+	}
+}
+
+// currencySymbols maps currency symbols to ISO codes; longer symbols are
+// listed first so "R$" wins over "$"
+var currencySymbols = []struct {
+	symbol string
+	code   string
+}{
+	{"R$", "BRL"},
+	{"US$", "USD"},
+	{"zł", "PLN"},
+	{"lei", "RON"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"¥", "JPY"},
+	{"₹", "INR"},
+	{"₽", "RUB"},
+	{"₺", "TRY"},
+	{"₩", "KRW"},
+	{"$", "USD"},
+}
+
+// currencyCodePattern matches an ISO 4217 style currency code
+var currencyCodePattern = regexp.MustCompile(`\b[A-Z]{3}\b`)
+
+// currencyCodes are the active ISO 4217 codes recognised in prices. ALL, CUP,
+// MOP, SOS and TOP are left out as they are mostly English words in text.
+var currencyCodes = toSet(strings.Fields(`
+	AED AFN AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB
+	BRL BSD BTN BWP BYN BZD CAD CDF CHF CLP CNY COP CRC CVE CZK DJF DKK DOP
+	DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD HNL HTG
+	HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD
+	KZT LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MRU MUR MVR MWK MXN
+	MYR MZN NAD NGN NIO NOK NPR NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON
+	RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SRD SSP STN SVC SYP SZL THB
+	TJS TMT TND TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD
+	XOF XPF YER ZAR ZMW ZWL
+`))
+
+// toSet builds a lookup set from a list of strings
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// parseCurrency parses a price into {amount, currency}. The currency comes
+// from an ISO code or symbol in the text, falling back to the "currency" param.
+func parseCurrency(text string, params map[string]interface{}) (map[string]interface{}, error) {
+	decimal, thousands := numberSeparators(params)
+	amount, err := parseNumber(text, decimal, thousands)
+	if err != nil {
+		return nil, err
+	}
+
+	var currency string
+	for _, code := range currencyCodePattern.FindAllString(text, -1) {
+		if currencyCodes[code] {
+			currency = code
+			break
+		}
+	}
+	if currency == "" {
+		for _, candidate := range currencySymbols {
+			if strings.Contains(text, candidate.symbol) {
+				currency = candidate.code
+				break
+			}
+		}
+	}
+	if currency == "" {
+		currency = stringParam(params, "currency", "")
+	}
+
+	return map[string]interface{}{
+		"amount":   amount,
+		"currency": currency,
+	}, nil
+}
+
+// defaultDateLayouts are tried when a date transform declares no layouts
+var defaultDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"02.01.2006",
+}
+
+// parseDate parses a date using the "layout"/"layouts" params (Go reference
+// layouts) in the "timezone" location, defaulting to UTC
+func parseDate(text string, params map[string]interface{}) (time.Time, error) {
+	layouts := stringListParam(params, "layouts")
+	if layout := stringParam(params, "layout", ""); layout != "" {
+		layouts = append([]string{layout}, layouts...)
+	}
+	if len(layouts) == 0 {
+		layouts = defaultDateLayouts
+	}
+
+	location := time.UTC
+	if timezone := stringParam(params, "timezone", ""); timezone != "" {
+		loaded, err := time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %s: %w", timezone, err)
+		}
+		location = loaded
+	}
+
+	text = strings.TrimSpace(text)
+	for _, layout := range layouts {
+		if parsed, err := time.ParseInLocation(layout, text, location); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q does not match any layout", truncate(text, 100))
+}
+
+// defaultTrueValues and defaultFalseValues are used by the boolean transform
+// when no explicit mapping is configured
+var (
+	defaultTrueValues  = []string{"true", "yes", "y", "1", "on", "in stock", "available"}
+	defaultFalseValues = []string{"false", "no", "n", "0", "off", "out of stock", "unavailable", "sold out"}
+)
+
+// parseBoolean maps text to a boolean using the "true_values" and
+// "false_values" params (case-insensitive)
+func parseBoolean(text string, params map[string]interface{}) (bool, error) {
+	trueValues := stringListParam(params, "true_values")
+	if len(trueValues) == 0 {
+		trueValues = defaultTrueValues
+	}
+	falseValues := stringListParam(params, "false_values")
+	if len(falseValues) == 0 {
+		falseValues = defaultFalseValues
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(text))
+	for _, value := range trueValues {
+		if normalized == strings.ToLower(value) {
+			return true, nil
+		}
+	}
+	for _, value := range falseValues {
+		if normalized == strings.ToLower(value) {
+			return false, nil
+		}
+	}
+
+	if fallback, ok := params["default"].(bool); ok {
+		return fallback, nil
+	}
+	return false, fmt.Errorf("cannot map %q to a boolean", truncate(text, 100))
+}
+
+// coerceOutputType converts a value to the field's "output_type" (string,
+// int, float, bool or date) so results carry real JSON types. Lists are
+// coerced element by element; dates are emitted as RFC 3339 strings.
+func coerceOutputType(value interface{}, config map[string]interface{}) (interface{}, error) {
+	outputType, _ := config["output_type"].(string)
+	return coerceValue(value, outputType)
+}
+
+// coerceValue converts a single value to the given output type
+func coerceValue(value interface{}, outputType string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	if list, ok := toList(value); ok && outputType != "" {
+		results := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			result, err := coerceValue(item, outputType)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+
+	switch outputType {
+	case "":
+		if date, ok := value.(time.Time); ok {
+			return date.Format(time.RFC3339), nil
+		}
+		return value, nil
+	case "string":
+		if date, ok := value.(time.Time); ok {
+			return date.Format(time.RFC3339), nil
+		}
+		if text, ok := value.(string); ok {
+			return text, nil
+		}
+		return fmt.Sprint(value), nil
+	case "float":
+		return toFloat(value)
+	case "int":
+		number, err := toFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		if number != math.Trunc(number) {
+			return nil, fmt.Errorf("%v is not an integer", number)
+		}
+		return int64(number), nil
+	case "bool":
+		switch typed := value.(type) {
+		case bool:
+			return typed, nil
+		case string:
+			return parseBoolean(typed, nil)
+		default:
+			number, err := toFloat(value)
+			if err != nil {
+				return nil, err
+			}
+			return number != 0, nil
+		}
+	case "date":
+		switch typed := value.(type) {
+		case time.Time:
+			return typed.Format(time.RFC3339), nil
+		case string:
+			date, err := parseDate(typed, nil)
+			if err != nil {
+				return nil, err
+			}
+			return date.Format(time.RFC3339), nil
+		default:
+			return nil, fmt.Errorf("cannot convert %T to date", value)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output_type: %s", outputType)
+	}
+}
+
+// toFloat converts numeric values and number-like strings to float64
+func toFloat(value interface{}) (float64, error) {
+	switch typed := value.(type) {
+	case float64:
+		return typed, nil
+	case float32:
+		return float64(typed), nil
+	case int:
+		return float64(typed), nil
+	case int64:
+		return float64(typed), nil
+	case bool:
+		if typed {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return parseNumber(typed, "", "")
+	case map[string]interface{}:
+		// Currency values coerce to their amount
+		if amount, ok := typed["amount"]; ok {
+			return toFloat(amount)
+		}
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", value)
+}
+
+// toList returns the elements of list values produced by extraction
+func toList(value interface{}) ([]interface{}, bool) {
+	switch typed := value.(type) {
+	case []interface{}:
+		return typed, true
+	case []string:
+		list := make([]interface{}, len(typed))
+		for i, item := range typed {
+			list[i] = item
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+// toStringList returns list values as strings
+func toStringList(value interface{}) ([]string, bool) {
+	list, ok := toList(value)
+	if !ok {
+		return nil, false
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return items, true
+}
+
+// stringParam reads a string transform parameter
+func stringParam(params map[string]interface{}, key, defaultValue string) string {
+	if value, ok := params[key].(string); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// intParam reads an integer transform parameter; JSON numbers decode as float64
+func intParam(params map[string]interface{}, key string) (int, bool) {
+	switch value := params[key].(type) {
+	case float64:
+		return int(value), true
+	case int:
+		return value, true
+	default:
+		return 0, false
+	}
+}
+
+// stringListParam reads a parameter that may be a single string or a list
+func stringListParam(params map[string]interface{}, key string) []string {
+	switch value := params[key].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var items []string
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				items = append(items, text)
+			}
+		}
+		return items
+	default:
+		return nil
+	}
+}